@@ -0,0 +1,186 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// operatorOwnedOptions are gatekeeper options that are always generated by the operator,
+// mapped to the spec field that should be used to set them
+var operatorOwnedOptions = map[string]string{
+	"discovery-url": "oidcurl",
+}
+
+// typedOptions returns the gatekeeper options set through the typed spec fields, mapped to the
+// path of the field setting them. These take precedence over the same options in the default configuration.
+func (spec *GogatekeeperSpec) typedOptions() map[string]string {
+	options := map[string]string{}
+	set := func(option, field string, isSet bool) {
+		if isSet {
+			options[option] = field
+		}
+	}
+
+	set("client-id", "clientID", spec.ClientID != "")
+	set("upstream-url", "upstreamURL", spec.UpstreamURL != "")
+	set("upstream-url", "detectUpstream", spec.DetectUpstream)
+	set("listen", "listen", spec.Listen != "")
+	set("listen-admin", "listenAdmin", spec.ListenAdmin != "")
+	set("scopes", "scopes", len(spec.Scopes) > 0)
+	set("enable-refresh-tokens", "enableRefreshTokens", spec.EnableRefreshTokens != nil)
+	set("enable-default-deny", "enableDefaultDeny", spec.EnableDefaultDeny != nil)
+	set("skip-upstream-tls-verify", "skipUpstreamTLSVerify", spec.SkipUpstreamTLSVerify != nil)
+	set("skip-openid-provider-tls-verify", "skipOpenIDProviderTLSVerify", spec.SkipOpenIDProviderTLSVerify != nil)
+
+	set("upstream-ca", "upstreamTLS.caSecretRef", spec.UpstreamTLS != nil && spec.UpstreamTLS.CASecretRef != nil)
+	set("tls-cert", "tls", spec.TLS != nil)
+	set("tls-private-key", "tls", spec.TLS != nil)
+
+	if cookie := spec.Cookie; cookie != nil {
+		set("cookie-domain", "cookie.domain", cookie.Domain != "")
+		set("cookie-path", "cookie.path", cookie.Path != "")
+		set("cookie-access-name", "cookie.accessName", cookie.AccessName != "")
+		set("cookie-refresh-name", "cookie.refreshName", cookie.RefreshName != "")
+		set("secure-cookie", "cookie.secure", cookie.Secure != nil)
+		set("http-only-cookie", "cookie.httpOnly", cookie.HTTPOnly != nil)
+		set("same-site-cookie", "cookie.sameSite", cookie.SameSite != "")
+	}
+
+	return options
+}
+
+// GeneratesEncryptionKey reports whether the operator generates the Gogatekeeper's encryption key, which it
+// does unless the key is set through encryptionKeyRef or the encryption-key option of the default configuration
+func (gk *Gogatekeeper) GeneratesEncryptionKey() bool {
+	return gk.Spec.EncryptionKeyRef == nil && defaultConfigString(gk.Spec.DefaultConfig, "encryption-key") == ""
+}
+
+// knownGatekeeperOptions are the configuration file options understood by gatekeeper
+// (see `gatekeeper --help`)
+var knownGatekeeperOptions = map[string]bool{
+	"access-token-duration":            true,
+	"add-claims":                       true,
+	"base-uri":                         true,
+	"client-id":                        true,
+	"client-secret":                    true,
+	"content-security-policy":          true,
+	"cookie-access-name":               true,
+	"cookie-domain":                    true,
+	"cookie-id-token-name":             true,
+	"cookie-oauth-state-name":          true,
+	"cookie-path":                      true,
+	"cookie-pkce-name":                 true,
+	"cookie-refresh-name":              true,
+	"cookie-request-uri-name":          true,
+	"cors-credentials":                 true,
+	"cors-exposed-headers":             true,
+	"cors-headers":                     true,
+	"cors-max-age":                     true,
+	"cors-methods":                     true,
+	"cors-origins":                     true,
+	"custom-http-methods":              true,
+	"disable-all-logging":              true,
+	"discovery-url":                    true,
+	"enable-authorization-cookies":     true,
+	"enable-authorization-header":      true,
+	"enable-brotli":                    true,
+	"enable-compression":               true,
+	"enable-default-deny":              true,
+	"enable-encrypted-token":           true,
+	"enable-forwarding":                true,
+	"enable-https-redirection":         true,
+	"enable-id-token-cookie":           true,
+	"enable-idp-session-check":         true,
+	"enable-json-logging":              true,
+	"enable-logging":                   true,
+	"enable-login-handler":             true,
+	"enable-logout-redirect":           true,
+	"enable-metrics":                   true,
+	"enable-pkce":                      true,
+	"enable-profiling":                 true,
+	"enable-refresh-tokens":            true,
+	"enable-request-id":                true,
+	"enable-security-filter":           true,
+	"enable-self-signed-tls":           true,
+	"enable-session-cookies":           true,
+	"enable-token-header":              true,
+	"enable-uma":                       true,
+	"encryption-key":                   true,
+	"error-page":                       true,
+	"filter-browser-xss":               true,
+	"filter-content-nosniff":           true,
+	"filter-frame-deny":                true,
+	"force-encrypted-cookie":           true,
+	"forbidden-page":                   true,
+	"forwarding-domains":               true,
+	"forwarding-password":              true,
+	"forwarding-username":              true,
+	"headers":                          true,
+	"hostnames":                        true,
+	"http-only-cookie":                 true,
+	"listen":                           true,
+	"listen-admin":                     true,
+	"listen-admin-scheme":              true,
+	"listen-http":                      true,
+	"localhost-metrics":                true,
+	"match-claims":                     true,
+	"max-idle-connections":             true,
+	"max-idle-connections-per-host":    true,
+	"no-proxy":                         true,
+	"no-redirects":                     true,
+	"oauth-uri":                        true,
+	"openid-provider-proxy":            true,
+	"openid-provider-retry-count":      true,
+	"openid-provider-timeout":          true,
+	"post-login-redirect-path":         true,
+	"preserve-host":                    true,
+	"redirection-url":                  true,
+	"request-id-header":                true,
+	"resources":                        true,
+	"response-headers":                 true,
+	"revocation-url":                   true,
+	"same-site-cookie":                 true,
+	"scopes":                           true,
+	"secure-cookie":                    true,
+	"server-idle-timeout":              true,
+	"server-read-timeout":              true,
+	"server-write-timeout":             true,
+	"sign-in-page":                     true,
+	"skip-access-token-clientid-check": true,
+	"skip-access-token-issuer-check":   true,
+	"skip-openid-provider-tls-verify":  true,
+	"skip-token-verification":          true,
+	"skip-upstream-tls-verify":         true,
+	"store-url":                        true,
+	"tags":                             true,
+	"tls-admin-ca-certificate":         true,
+	"tls-admin-cert":                   true,
+	"tls-admin-client-certificate":     true,
+	"tls-admin-private-key":            true,
+	"tls-ca-certificate":               true,
+	"tls-ca-key":                       true,
+	"tls-cert":                         true,
+	"tls-client-certificate":           true,
+	"tls-private-key":                  true,
+	"tls-use-modern-settings":          true,
+	"upstream-ca":                      true,
+	"upstream-expect-continue-timeout": true,
+	"upstream-keepalive-timeout":       true,
+	"upstream-keepalives":              true,
+	"upstream-response-header-timeout": true,
+	"upstream-timeout":                 true,
+	"upstream-tls-handshake-timeout":   true,
+	"upstream-url":                     true,
+	"verbose":                          true,
+}
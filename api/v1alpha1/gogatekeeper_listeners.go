@@ -0,0 +1,158 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// defaultListen is the address gatekeeper's proxy listens on when listen is not configured
+const defaultListen = ":3000"
+
+// gatekeeperListeners are the listener options of a gatekeeper configuration, along with the URIs its own
+// endpoints are served under
+type gatekeeperListeners struct {
+	Listen            string `yaml:"listen"`
+	ListenAdmin       string `yaml:"listen-admin"`
+	ListenAdminScheme string `yaml:"listen-admin-scheme"`
+	TLSCert           string `yaml:"tls-cert"`
+	TLSAdminCert      string `yaml:"tls-admin-cert"`
+	BaseURI           string `yaml:"base-uri"`
+	OAuthURI          string `yaml:"oauth-uri"`
+}
+
+// parseListeners reads the listener and endpoint options of a rendered gatekeeper configuration
+func parseListeners(config string) (*gatekeeperListeners, error) {
+	listeners := &gatekeeperListeners{}
+	if err := yamlv3.Unmarshal([]byte(config), listeners); err != nil {
+		return nil, fmt.Errorf("invalid gatekeeper configuration: %v", err)
+	}
+	return listeners, nil
+}
+
+// override applies the listener and endpoint options set as command line arguments, which take precedence over the configuration
+func (l *gatekeeperListeners) override(args map[string]string) {
+	for option, value := range args {
+		switch option {
+		case "listen":
+			l.Listen = value
+		case "listen-admin":
+			l.ListenAdmin = value
+		case "listen-admin-scheme":
+			l.ListenAdminScheme = value
+		case "tls-cert":
+			l.TLSCert = value
+		case "tls-admin-cert":
+			l.TLSAdminCert = value
+		case "base-uri":
+			l.BaseURI = value
+		case "oauth-uri":
+			l.OAuthURI = value
+		}
+	}
+}
+
+func (l *gatekeeperListeners) proxyAddress() string {
+	if l.Listen == "" {
+		return defaultListen
+	}
+	return l.Listen
+}
+
+// separateAdmin reports whether the admin endpoints have their own listener, gatekeeper serves them on the
+// proxy listener when listen-admin is unset or the same address
+func (l *gatekeeperListeners) separateAdmin() bool {
+	return l.ListenAdmin != "" && l.ListenAdmin != l.proxyAddress()
+}
+
+// containerPorts returns the ports gatekeeper listens on, named gatekeeper for the proxy and
+// gatekeeper-admin for the admin endpoints
+func (l *gatekeeperListeners) containerPorts() ([]corev1.ContainerPort, error) {
+	proxyPort, err := listenPort(l.proxyAddress())
+	if err != nil {
+		return nil, err
+	}
+	ports := []corev1.ContainerPort{{Name: "gatekeeper", ContainerPort: proxyPort}}
+
+	if l.separateAdmin() {
+		adminPort, err := listenPort(l.ListenAdmin)
+		if err != nil {
+			return nil, err
+		}
+		if adminPort == proxyPort {
+			return nil, fmt.Errorf("listen-admin %q uses the same port as listen %q", l.ListenAdmin, l.proxyAddress())
+		}
+		ports = append(ports, corev1.ContainerPort{Name: "gatekeeper-admin", ContainerPort: adminPort})
+	}
+	return ports, nil
+}
+
+// healthPath returns the path of gatekeeper's health endpoint, under base-uri and oauth-uri
+func (l *gatekeeperListeners) healthPath() string {
+	oauthURI := l.OAuthURI
+	if oauthURI == "" {
+		oauthURI = defaultOAuthURI
+	}
+	return strings.TrimSuffix(l.BaseURI, "/") + "/" + strings.Trim(oauthURI, "/") + healthURI
+}
+
+// healthCheck returns the request probing gatekeeper's health endpoint, which is served by the admin
+// listener when there is one and by the proxy otherwise
+func (l *gatekeeperListeners) healthCheck() (*corev1.HTTPGetAction, error) {
+	address, scheme := l.proxyAddress(), corev1.URISchemeHTTP
+	if l.TLSCert != "" {
+		scheme = corev1.URISchemeHTTPS
+	}
+
+	// The admin listener only serves HTTPS if it has a certificate, its own or else the proxy's
+	if l.separateAdmin() {
+		address, scheme = l.ListenAdmin, corev1.URISchemeHTTP
+		if l.ListenAdminScheme != "http" && (l.TLSAdminCert != "" || l.TLSCert != "") {
+			scheme = corev1.URISchemeHTTPS
+		}
+	}
+
+	port, err := listenPort(address)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.HTTPGetAction{
+		Path:   l.healthPath(),
+		Port:   intstr.FromInt(int(port)),
+		Scheme: scheme,
+	}, nil
+}
+
+// listenPort returns the port of a gatekeeper listen address such as ":3000"
+func listenPort(address string) (int32, error) {
+	_, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, fmt.Errorf("invalid listen address %q: %v", address, err)
+	}
+	port, err := strconv.ParseInt(portString, 10, 32)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid listen address %q: port must be between 1 and 65535", address)
+	}
+	return int32(port), nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestHealthCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		args    map[string]string
+		port    int
+		scheme  corev1.URIScheme
+		path    string
+		invalid bool
+	}{
+		{
+			name:   "default listener",
+			port:   3000,
+			scheme: corev1.URISchemeHTTP,
+		},
+		{
+			name:   "proxy listener",
+			config: "listen: 0.0.0.0:8080\n",
+			port:   8080,
+			scheme: corev1.URISchemeHTTP,
+		},
+		{
+			name:   "https proxy listener",
+			config: "listen: :8443\ntls-cert: /etc/gatekeeper/tls/tls.crt\n",
+			port:   8443,
+			scheme: corev1.URISchemeHTTPS,
+		},
+		{
+			name:   "admin listener",
+			config: "listen: :3000\nlisten-admin: :4000\n",
+			port:   4000,
+			scheme: corev1.URISchemeHTTP,
+		},
+		{
+			name:   "admin on the proxy listener",
+			config: "listen: :8443\nlisten-admin: :8443\ntls-cert: /etc/gatekeeper/tls/tls.crt\nlisten-admin-scheme: http\n",
+			port:   8443,
+			scheme: corev1.URISchemeHTTPS,
+		},
+		{
+			name:   "https admin listener",
+			config: "listen-admin: :4000\ntls-cert: /etc/gatekeeper/tls/tls.crt\n",
+			port:   4000,
+			scheme: corev1.URISchemeHTTPS,
+		},
+		{
+			name:   "http admin listener",
+			config: "listen-admin: :4000\nlisten-admin-scheme: http\ntls-cert: /etc/gatekeeper/tls/tls.crt\n",
+			port:   4000,
+			scheme: corev1.URISchemeHTTP,
+		},
+		{
+			name:   "base uri",
+			config: "base-uri: /app\n",
+			port:   3000,
+			scheme: corev1.URISchemeHTTP,
+			path:   "/app/oauth/health",
+		},
+		{
+			name:   "oauth uri",
+			config: "base-uri: /app/\noauth-uri: /auth\nlisten-admin: :4000\n",
+			port:   4000,
+			scheme: corev1.URISchemeHTTP,
+			path:   "/app/auth/health",
+		},
+		{
+			name:   "uri argument overrides",
+			config: "base-uri: /app\noauth-uri: /auth\n",
+			args:   map[string]string{"base-uri": "/other", "oauth-uri": "/login"},
+			port:   3000,
+			scheme: corev1.URISchemeHTTP,
+			path:   "/other/login/health",
+		},
+		{
+			name:    "invalid port",
+			config:  "listen: :http\n",
+			invalid: true,
+		},
+		{
+			name:    "invalid config",
+			config:  "listen: [:3000]\n",
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listeners, err := parseListeners(test.config)
+			var check *corev1.HTTPGetAction
+			if err == nil {
+				listeners.override(test.args)
+				check, err = listeners.healthCheck()
+			}
+			if test.invalid {
+				if err == nil {
+					t.Errorf("expected an error, got %v", check)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := test.path
+			if path == "" {
+				path = "/oauth/health"
+			}
+			if check.Path != path || check.Port.IntValue() != test.port || check.Scheme != test.scheme {
+				t.Errorf("expected %s://:%d%s, got %s://:%s%s", test.scheme, test.port, path, check.Scheme, check.Port.String(), check.Path)
+			}
+		})
+	}
+}
+
+func TestContainerPorts(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		args     map[string]string
+		expected []corev1.ContainerPort
+		invalid  bool
+	}{
+		{
+			name:     "default listener",
+			expected: []corev1.ContainerPort{{Name: "gatekeeper", ContainerPort: 3000}},
+		},
+		{
+			name:   "admin listener",
+			config: "listen: :8443\nlisten-admin: :4000\n",
+			expected: []corev1.ContainerPort{
+				{Name: "gatekeeper", ContainerPort: 8443},
+				{Name: "gatekeeper-admin", ContainerPort: 4000},
+			},
+		},
+		{
+			name:     "admin on the proxy listener",
+			config:   "listen: :8443\nlisten-admin: :8443\n",
+			expected: []corev1.ContainerPort{{Name: "gatekeeper", ContainerPort: 8443}},
+		},
+		{
+			name:   "argument overrides",
+			config: "listen: :8443\n",
+			args:   map[string]string{"listen": ":9443", "listen-admin": "127.0.0.1:4000", "upstream-url": "http://127.0.0.1:80"},
+			expected: []corev1.ContainerPort{
+				{Name: "gatekeeper", ContainerPort: 9443},
+				{Name: "gatekeeper-admin", ContainerPort: 4000},
+			},
+		},
+		{
+			name:    "admin port clash",
+			config:  "listen: :8443\nlisten-admin: 127.0.0.1:8443\n",
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listeners, err := parseListeners(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listeners.override(test.args)
+			ports, err := listeners.containerPorts()
+			if test.invalid {
+				if err == nil {
+					t.Errorf("expected an error, got %v", ports)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ports) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, ports)
+			}
+			for i := range ports {
+				if ports[i] != test.expected[i] {
+					t.Errorf("expected %v, got %v", test.expected[i], ports[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strings"
+
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// injectedAnnotation is set on pods the gatekeeper container was injected into, naming the Gogatekeeper used.
+// It uses the operator's own prefix, as gatekeeper.gogatekeeper/ annotations are passed to gatekeeper as options.
+const injectedAnnotation = "gatekeeper.theendbeta.me/injected"
+
+// injection collects everything added to a pod by gatekeeperInjector
+type injection struct {
+	container        corev1.Container
+	volumes          []corev1.Volume
+	imagePullSecrets []corev1.LocalObjectReference
+	annotations      map[string]string
+}
+
+// mount adds a volume to the pod, mounted read-only in the gatekeeper container
+func (inj *injection) mount(volume corev1.Volume, mountPath string) {
+	inj.volumes = append(inj.volumes, volume)
+	inj.container.VolumeMounts = append(inj.container.VolumeMounts, corev1.VolumeMount{
+		Name:      volume.Name,
+		MountPath: mountPath,
+		ReadOnly:  true,
+	})
+}
+
+// patches returns the JSON patch operations adding the injection to the pod. The gatekeeper container and
+// volumes replace the ones with the same names when the pod was already injected, so that re-invoking the
+// webhook updates them in place. Every operation adds or replaces a single list item or map key, so that fields
+// of the pod unknown to this operator are never touched.
+func (inj *injection) patches(pod *corev1.Pod) []jsonpatch.JsonPatchOperation {
+	patches := []jsonpatch.JsonPatchOperation{}
+
+	if i := containerIndex(pod.Spec.Containers, inj.container.Name); i >= 0 {
+		patches = append(patches, jsonpatch.NewOperation("replace", fmt.Sprintf("/spec/containers/%d", i), inj.container))
+	} else if len(pod.Spec.Containers) == 0 {
+		patches = append(patches, jsonpatch.NewOperation("add", "/spec/containers", []corev1.Container{inj.container}))
+	} else {
+		patches = append(patches, jsonpatch.NewOperation("add", "/spec/containers/-", inj.container))
+	}
+
+	hasVolumes := len(pod.Spec.Volumes) > 0
+	for _, volume := range inj.volumes {
+		switch i := volumeIndex(pod.Spec.Volumes, volume.Name); {
+		case i >= 0:
+			patches = append(patches, jsonpatch.NewOperation("replace", fmt.Sprintf("/spec/volumes/%d", i), volume))
+		case !hasVolumes:
+			patches = append(patches, jsonpatch.NewOperation("add", "/spec/volumes", []corev1.Volume{volume}))
+			hasVolumes = true
+		default:
+			patches = append(patches, jsonpatch.NewOperation("add", "/spec/volumes/-", volume))
+		}
+	}
+
+	hasPullSecrets := len(pod.Spec.ImagePullSecrets) > 0
+	for _, secret := range inj.imagePullSecrets {
+		switch {
+		case hasPullSecret(pod.Spec.ImagePullSecrets, secret.Name):
+			continue
+		case !hasPullSecrets:
+			patches = append(patches, jsonpatch.NewOperation("add", "/spec/imagePullSecrets", []corev1.LocalObjectReference{secret}))
+			hasPullSecrets = true
+		default:
+			patches = append(patches, jsonpatch.NewOperation("add", "/spec/imagePullSecrets/-", secret))
+		}
+	}
+
+	if len(inj.annotations) > 0 && pod.Annotations == nil {
+		patches = append(patches, jsonpatch.NewOperation("add", "/metadata/annotations", map[string]string{}))
+	}
+	for key, value := range inj.annotations {
+		// add replaces an existing member, see RFC 6902
+		patches = append(patches, jsonpatch.NewOperation("add", "/metadata/annotations/"+escapeJSONPointer(key), value))
+	}
+
+	return patches
+}
+
+// conflicts returns an error if the pod, which was not injected before, already has a container or
+// volume with the name of one of the injection's
+func (inj *injection) conflicts(pod *corev1.Pod) error {
+	if containerIndex(pod.Spec.Containers, inj.container.Name) >= 0 {
+		return fmt.Errorf("pod already has a container named %s", inj.container.Name)
+	}
+	for _, volume := range inj.volumes {
+		if volumeIndex(pod.Spec.Volumes, volume.Name) >= 0 {
+			return fmt.Errorf("pod already has a volume named %s", volume.Name)
+		}
+	}
+	return nil
+}
+
+// portConflicts returns an error if one of the pod's other containers already uses a port the gatekeeper
+// container listens on, containers of a pod sharing its network namespace
+func (inj *injection) portConflicts(pod *corev1.Pod) error {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == inj.container.Name {
+			continue
+		}
+		for _, port := range container.Ports {
+			for _, gatekeeperPort := range inj.container.Ports {
+				if port.ContainerPort == gatekeeperPort.ContainerPort && protocol(port) == protocol(gatekeeperPort) {
+					return fmt.Errorf("container %s already uses port %d, set a different gatekeeper listen address", container.Name, port.ContainerPort)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// protocol returns the protocol of a container port, which defaults to TCP
+func protocol(port corev1.ContainerPort) corev1.Protocol {
+	if port.Protocol == "" {
+		return corev1.ProtocolTCP
+	}
+	return port.Protocol
+}
+
+func hasPullSecret(secrets []corev1.LocalObjectReference, name string) bool {
+	for _, secret := range secrets {
+		if secret.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containerIndex(containers []corev1.Container, name string) int {
+	for i := range containers {
+		if containers[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func volumeIndex(volumes []corev1.Volume, name string) int {
+	for i := range volumes {
+		if volumes[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// escapeJSONPointer escapes a JSON pointer reference token, see RFC 6901
+func escapeJSONPointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultOAuthURI is the prefix of gatekeeper's own endpoints when oauth-uri is not configured
+const defaultOAuthURI = "/oauth"
+
+// healthURI is the gatekeeper endpoint the injected container is probed on, served under base-uri and oauth-uri
+const healthURI = "/health"
+
+// sidecarProbes returns the liveness and readiness probes of the gatekeeper container, tuned by the
+// Gogatekeeper's spec.sidecar
+func sidecarProbes(gk *Gogatekeeper, check *corev1.HTTPGetAction) (*corev1.Probe, *corev1.Probe) {
+	var liveness, readiness *ProbeThresholds
+	if gk != nil && gk.Spec.Sidecar != nil {
+		liveness, readiness = gk.Spec.Sidecar.LivenessProbe, gk.Spec.Sidecar.ReadinessProbe
+	}
+	return newProbe(check, liveness), newProbe(check, readiness)
+}
+
+func newProbe(check *corev1.HTTPGetAction, thresholds *ProbeThresholds) *corev1.Probe {
+	probe := &corev1.Probe{
+		Handler: corev1.Handler{HTTPGet: check.DeepCopy()},
+	}
+	if thresholds != nil {
+		probe.InitialDelaySeconds = thresholds.InitialDelaySeconds
+		probe.TimeoutSeconds = thresholds.TimeoutSeconds
+		probe.PeriodSeconds = thresholds.PeriodSeconds
+		probe.SuccessThreshold = thresholds.SuccessThreshold
+		probe.FailureThreshold = thresholds.FailureThreshold
+	}
+	return probe
+}
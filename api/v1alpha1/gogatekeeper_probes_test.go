@@ -0,0 +1,41 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSidecarProbes(t *testing.T) {
+	check := &corev1.HTTPGetAction{Path: "/oauth/health"}
+	gk := &Gogatekeeper{Spec: GogatekeeperSpec{Sidecar: &SidecarSpec{
+		ReadinessProbe: &ProbeThresholds{PeriodSeconds: 5, FailureThreshold: 1},
+	}}}
+
+	liveness, readiness := sidecarProbes(gk, check)
+	if liveness.HTTPGet == nil || liveness.HTTPGet.Path != check.Path || liveness.PeriodSeconds != 0 {
+		t.Errorf("expected an untuned liveness probe, got %v", liveness)
+	}
+	if readiness.HTTPGet == nil || readiness.PeriodSeconds != 5 || readiness.FailureThreshold != 1 {
+		t.Errorf("expected a tuned readiness probe, got %v", readiness)
+	}
+	if liveness.HTTPGet == readiness.HTTPGet {
+		t.Error("expected the probes not to share their request")
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// resourceAnnotations are the gatekeeper.gogatekeeper/ annotation suffixes overriding the gatekeeper
+// container's resources, mapped to the resource and whether they set its limit
+var resourceAnnotations = map[string]struct {
+	name  corev1.ResourceName
+	limit bool
+}{
+	"cpu-request":    {corev1.ResourceCPU, false},
+	"cpu-limit":      {corev1.ResourceCPU, true},
+	"memory-request": {corev1.ResourceMemory, false},
+	"memory-limit":   {corev1.ResourceMemory, true},
+}
+
+// ParseResources builds resource requirements from cpu and memory quantities, leaving empty ones unset
+func ParseResources(cpuRequest, cpuLimit, memoryRequest, memoryLimit string) (corev1.ResourceRequirements, error) {
+	overrides := map[string]string{
+		"cpu-request":    cpuRequest,
+		"cpu-limit":      cpuLimit,
+		"memory-request": memoryRequest,
+		"memory-limit":   memoryLimit,
+	}
+	resources := corev1.ResourceRequirements{}
+	if err := overrideResources(&resources, overrides); err != nil {
+		return corev1.ResourceRequirements{}, err
+	}
+	return resources, nil
+}
+
+// sidecarResources returns the gatekeeper container's resources: the operator's defaults, overridden per resource
+// by the Gogatekeeper's spec.sidecar.resources and then by the pod's resource annotations
+func (a *gatekeeperInjector) sidecarResources(gk *Gogatekeeper, annotations map[string]string) (corev1.ResourceRequirements, error) {
+	resources := *a.Options.Resources.DeepCopy()
+	if gk != nil && gk.Spec.Sidecar != nil && gk.Spec.Sidecar.Resources != nil {
+		mergeResourceList(&resources.Requests, gk.Spec.Sidecar.Resources.Requests)
+		mergeResourceList(&resources.Limits, gk.Spec.Sidecar.Resources.Limits)
+	}
+
+	if err := overrideResources(&resources, annotations); err != nil {
+		return corev1.ResourceRequirements{}, err
+	}
+	return resources, nil
+}
+
+// overrideResources sets the resources named by resourceAnnotations keys to their quantity
+func overrideResources(resources *corev1.ResourceRequirements, overrides map[string]string) error {
+	for key, value := range overrides {
+		annotation, ok := resourceAnnotations[key]
+		if !ok || value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %v", key, value, err)
+		}
+
+		list := &resources.Requests
+		if annotation.limit {
+			list = &resources.Limits
+		}
+		mergeResourceList(list, corev1.ResourceList{annotation.name: quantity})
+	}
+	return nil
+}
+
+func mergeResourceList(list *corev1.ResourceList, overrides corev1.ResourceList) {
+	if len(overrides) == 0 {
+		return
+	}
+	if *list == nil {
+		*list = corev1.ResourceList{}
+	}
+	for name, quantity := range overrides {
+		(*list)[name] = quantity.DeepCopy()
+	}
+}
+
+// defaultSecurityContext returns a security context for the gatekeeper container that complies with the
+// restricted Pod Security Standard
+func defaultSecurityContext() *corev1.SecurityContext {
+	runAsNonRoot := true
+	readOnlyRootFilesystem := true
+	allowPrivilegeEscalation := false
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             &runAsNonRoot,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// sidecarSecurityContext returns the Gogatekeeper's spec.sidecar.securityContext if set, or the restricted default
+func sidecarSecurityContext(gk *Gogatekeeper) *corev1.SecurityContext {
+	if gk != nil && gk.Spec.Sidecar != nil && gk.Spec.Sidecar.SecurityContext != nil {
+		return gk.Spec.Sidecar.SecurityContext.DeepCopy()
+	}
+	return defaultSecurityContext()
+}
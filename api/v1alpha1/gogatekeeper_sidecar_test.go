@@ -0,0 +1,136 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestSidecarResources(t *testing.T) {
+	defaults, err := ParseResources("10m", "", "32Mi", "128Mi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	injector := &gatekeeperInjector{Options: InjectorOptions{Resources: defaults}}
+
+	gk := &Gogatekeeper{Spec: GogatekeeperSpec{Sidecar: &SidecarSpec{Resources: &corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+	}}}}
+
+	tests := []struct {
+		name        string
+		gk          *Gogatekeeper
+		annotations map[string]string
+		expected    corev1.ResourceRequirements
+		invalid     bool
+	}{
+		{
+			name:     "operator defaults",
+			expected: defaults,
+		},
+		{
+			name: "spec overrides",
+			gk:   gk,
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m"), corev1.ResourceMemory: resource.MustParse("64Mi")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+			},
+		},
+		{
+			name:        "annotation overrides",
+			gk:          gk,
+			annotations: map[string]string{"cpu-request": "100m", "memory-limit": "1Gi"},
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("64Mi")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{"cpu-limit": "lots"},
+			invalid:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resources, err := injector.sidecarResources(test.gk, test.annotations)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("expected an error, got %v", resources)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, list := range []struct{ expected, actual corev1.ResourceList }{
+				{test.expected.Requests, resources.Requests},
+				{test.expected.Limits, resources.Limits},
+			} {
+				if len(list.expected) != len(list.actual) {
+					t.Errorf("expected %v, got %v", list.expected, list.actual)
+				}
+				for name, quantity := range list.expected {
+					if actual := list.actual[name]; actual.Cmp(quantity) != 0 {
+						t.Errorf("expected %s %s, got %s", name, quantity.String(), actual.String())
+					}
+				}
+			}
+		})
+	}
+
+	// The operator defaults must not be modified by overrides
+	if cpu := injector.Options.Resources.Requests[corev1.ResourceCPU]; cpu.String() != "10m" {
+		t.Errorf("operator default cpu request changed to %s", cpu.String())
+	}
+}
+
+func TestSidecarSecurityContext(t *testing.T) {
+	restricted := sidecarSecurityContext(nil)
+	if restricted.RunAsNonRoot == nil || !*restricted.RunAsNonRoot {
+		t.Error("expected runAsNonRoot by default")
+	}
+	if restricted.ReadOnlyRootFilesystem == nil || !*restricted.ReadOnlyRootFilesystem {
+		t.Error("expected a read-only root filesystem by default")
+	}
+	if restricted.AllowPrivilegeEscalation == nil || *restricted.AllowPrivilegeEscalation {
+		t.Error("expected privilege escalation to be disallowed by default")
+	}
+	if restricted.Capabilities == nil || len(restricted.Capabilities.Drop) != 1 || restricted.Capabilities.Drop[0] != "ALL" {
+		t.Errorf("expected all capabilities to be dropped by default, got %v", restricted.Capabilities)
+	}
+	if restricted.SeccompProfile == nil || restricted.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+		t.Errorf("expected the RuntimeDefault seccomp profile by default, got %v", restricted.SeccompProfile)
+	}
+
+	runAsUser := int64(1000)
+	gk := &Gogatekeeper{Spec: GogatekeeperSpec{Sidecar: &SidecarSpec{
+		SecurityContext: &corev1.SecurityContext{RunAsUser: &runAsUser},
+	}}}
+	override := sidecarSecurityContext(gk)
+	if override.RunAsUser == nil || *override.RunAsUser != runAsUser || override.RunAsNonRoot != nil {
+		t.Errorf("expected the spec security context to replace the default, got %v", override)
+	}
+	if override == gk.Spec.Sidecar.SecurityContext {
+		t.Error("expected the spec security context to be copied")
+	}
+}
@@ -17,12 +17,201 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// MergeStrategy defines how a list or map option in the default configuration is combined with the same option
+// managed by the operator
+// +kubebuilder:validation:Enum=Merge;Replace
+type MergeStrategy string
+
+const (
+	// MergeStrategyMerge merges maps key by key and appends missing list items
+	MergeStrategyMerge MergeStrategy = "Merge"
+	// MergeStrategyReplace replaces the default configuration value with the operator managed one
+	MergeStrategyReplace MergeStrategy = "Replace"
+)
+
+// CookieSpec defines the session cookies set by gatekeeper
+type CookieSpec struct {
+	// Domain of the cookies (cookie-domain)
+	// +optional
+	Domain string `json:"domain,omitempty"`
+
+	// Path of the cookies (cookie-path)
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Name of the access token cookie (cookie-access-name)
+	// +optional
+	AccessName string `json:"accessName,omitempty"`
+
+	// Name of the refresh token cookie (cookie-refresh-name)
+	// +optional
+	RefreshName string `json:"refreshName,omitempty"`
+
+	// Only send the cookies over HTTPS (secure-cookie)
+	// +optional
+	Secure *bool `json:"secure,omitempty"`
+
+	// Hide the cookies from javascript (http-only-cookie)
+	// +optional
+	HTTPOnly *bool `json:"httpOnly,omitempty"`
+
+	// SameSite attribute of the cookies (same-site-cookie)
+	// +optional
+	// +kubebuilder:validation:Enum=Strict;Lax;None
+	SameSite string `json:"sameSite,omitempty"`
+}
+
+// HTTPMethod is an HTTP request method
+// +kubebuilder:validation:Enum=GET;HEAD;POST;PUT;PATCH;DELETE;CONNECT;OPTIONS;TRACE
+type HTTPMethod string
+
+// Resource defines the authorization requirements for requests to a URI
+type Resource struct {
+	// URI pattern the resource applies to, e.g. "/admin/*" (uri)
+	// +kubebuilder:validation:Pattern=`^/\S*$`
+	URI string `json:"uri"`
+
+	// Methods the resource applies to, defaults to all methods (methods)
+	// +optional
+	Methods []HTTPMethod `json:"methods,omitempty"`
+
+	// Roles required to access the resource (roles)
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// Groups required to access the resource (groups)
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+
+	// Allow access to the resource without authentication (white-listed)
+	// +optional
+	WhiteListed bool `json:"whiteListed,omitempty"`
+
+	// Allow access with any of the roles instead of requiring all of them (require-any-role)
+	// +optional
+	RequireAnyRole bool `json:"requireAnyRole,omitempty"`
+}
+
+// EncryptionKeyRotation defines how often the operator generated encryption key is replaced
+type EncryptionKeyRotation struct {
+	// Time between rotations, e.g. "720h"
+	Interval metav1.Duration `json:"interval"`
+
+	// Time the replaced key is kept in the Secret under previous-encryption-key after a rotation,
+	// so that it can be restored if needed. It is removed straight away when not set.
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// CABundleSource selects a PEM encoded CA bundle from a ConfigMap or Secret key, exactly one must be set
+type CABundleSource struct {
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// SidecarSpec customizes the injected gatekeeper container
+type SidecarSpec struct {
+	// Compute resources of the gatekeeper container, overriding the operator's defaults per resource.
+	// Pods can override them further with the gatekeeper.gogatekeeper/{cpu,memory}-{request,limit} annotations.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Security context of the gatekeeper container, replacing the default one that complies with the
+	// restricted Pod Security Standard
+	// +optional
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// Timing and thresholds of the gatekeeper container's liveness probe
+	// +optional
+	LivenessProbe *ProbeThresholds `json:"livenessProbe,omitempty"`
+
+	// Timing and thresholds of the gatekeeper container's readiness probe
+	// +optional
+	ReadinessProbe *ProbeThresholds `json:"readinessProbe,omitempty"`
+}
+
+// ProbeThresholds tunes a probe of gatekeeper's health endpoint, unset fields use the Kubernetes defaults
+type ProbeThresholds struct {
+	// Seconds after the container has started before the probe is initiated
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+
+	// Seconds after which the probe times out
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// How often, in seconds, to perform the probe
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+
+	// Minimum consecutive successes for the probe to be considered successful after having failed,
+	// must be 1 for the liveness probe
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	SuccessThreshold int32 `json:"successThreshold,omitempty"`
+
+	// Minimum consecutive failures for the probe to be considered failed after having succeeded
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// UpstreamTLSSpec defines how gatekeeper authenticates the upstream. Gatekeeper can not present a client
+// certificate to the upstream, its tls-client-certificate option only verifies the clients of its own listener.
+type UpstreamTLSSpec struct {
+	// Secret key holding the PEM encoded CA bundle the upstream's certificate is verified with (upstream-ca)
+	// +optional
+	CASecretRef *corev1.SecretKeySelector `json:"caSecretRef,omitempty"`
+}
+
+// TLSSpec selects the certificate of gatekeeper's HTTPS listener, exactly one source must be set
+type TLSSpec struct {
+	// Name of an existing kubernetes.io/tls Secret used by every workload
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// Have the operator request a cert-manager Certificate for every workload using this resource
+	// +optional
+	CertManager *CertManagerTLS `json:"certManager,omitempty"`
+}
+
+// CertManagerTLS defines the cert-manager Certificates requested for each workload
+type CertManagerTLS struct {
+	// Issuer signing the certificates
+	IssuerRef IssuerReference `json:"issuerRef"`
+
+	// DNS names added to every certificate, next to the workload's <name>, <name>.<namespace>.svc
+	// and <name>.<namespace>.svc.cluster.local
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+}
+
+// IssuerReference references a cert-manager Issuer or ClusterIssuer
+type IssuerReference struct {
+	Name string `json:"name"`
+
+	// Kind of the issuer, Issuer when not set
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Group of the issuer, cert-manager.io when not set
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
 // GogatekeeperSpec defines the desired state of Gogatekeeper
 type GogatekeeperSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
@@ -31,18 +220,212 @@ type GogatekeeperSpec struct {
 	// OIDC discovery URL
 	OIDCURL string `json:"oidcurl"`
 
-	// yaml configuration
-	DefaultConfig string `json:"defaultconfig"`
+	// yaml configuration applied to every instance, overridden by the typed fields below
+	// +optional
+	DefaultConfig string `json:"defaultconfig,omitempty"`
+
+	// OIDC client ID of the applications using this resource (client-id)
+	// +optional
+	ClientID string `json:"clientID,omitempty"`
+
+	// Secret key holding the OIDC client secret, passed to gatekeeper as PROXY_CLIENT_SECRET
+	// +optional
+	ClientSecretRef *corev1.SecretKeySelector `json:"clientSecretRef,omitempty"`
+
+	// Secret key holding the session encryption key, passed to gatekeeper as PROXY_ENCRYPTION_KEY.
+	// A random key is generated and managed by the operator when neither this nor the encryption-key option of
+	// defaultconfig is set.
+	// +optional
+	EncryptionKeyRef *corev1.SecretKeySelector `json:"encryptionKeyRef,omitempty"`
+
+	// Periodically replace the generated encryption key and restart the workloads using it.
+	// Only applies when encryptionKeyRef is not set.
+	// +optional
+	EncryptionKeyRotation *EncryptionKeyRotation `json:"encryptionKeyRotation,omitempty"`
+
+	// gatekeeper image injected into pods, overriding the operator's default (--gatekeeper-image)
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Pull policy of the injected gatekeeper image
+	// +optional
+	// +kubebuilder:validation:Enum=Always;Never;IfNotPresent
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// Secrets used to pull the gatekeeper image, added to the pods it is injected into
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// Settings of the injected gatekeeper container
+	// +optional
+	Sidecar *SidecarSpec `json:"sidecar,omitempty"`
+
+	// Endpoint requests are proxied to (upstream-url)
+	// +optional
+	UpstreamURL string `json:"upstreamURL,omitempty"`
+
+	// Point upstream-url at the port of the injected pod's application container, http://127.0.0.1:<port>.
+	// Pods choose the port with the gatekeeper.gogatekeeper/upstream-port and upstream-container annotations
+	// when it is ambiguous.
+	// +optional
+	DetectUpstream bool `json:"detectUpstream,omitempty"`
+
+	// Interface the proxy listens on (listen), e.g. ":3000"
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[^:]*:[0-9]{1,5}$`
+	Listen string `json:"listen,omitempty"`
+
+	// Interface the admin endpoints, such as health and metrics, listen on (listen-admin), e.g. ":4000"
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[^:]*:[0-9]{1,5}$`
+	ListenAdmin string `json:"listenAdmin,omitempty"`
+
+	// Additional scopes to request from the provider (scopes)
+	// +optional
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Use refresh tokens to renew expired access tokens (enable-refresh-tokens)
+	// +optional
+	EnableRefreshTokens *bool `json:"enableRefreshTokens,omitempty"`
+
+	// Deny all requests not matched by a resource (enable-default-deny)
+	// +optional
+	EnableDefaultDeny *bool `json:"enableDefaultDeny,omitempty"`
+
+	// Skip verification of the upstream's TLS certificate (skip-upstream-tls-verify)
+	// +optional
+	SkipUpstreamTLSVerify *bool `json:"skipUpstreamTLSVerify,omitempty"`
+
+	// Skip verification of the OIDC provider's TLS certificate (skip-openid-provider-tls-verify)
+	// +optional
+	SkipOpenIDProviderTLSVerify *bool `json:"skipOpenIDProviderTLSVerify,omitempty"`
+
+	// PEM encoded CA bundle trusted for the OIDC provider, in addition to the system CAs.
+	// It is mounted into every gatekeeper container and used by the operator's provider checks.
+	// +optional
+	ProviderCA *CABundleSource `json:"providerCA,omitempty"`
+
+	// CA bundle used for HTTPS connections to the upstream, which must then use an https upstream-url
+	// +optional
+	UpstreamTLS *UpstreamTLSSpec `json:"upstreamTLS,omitempty"`
+
+	// Certificate served by gatekeeper's HTTPS listener (tls-cert, tls-private-key)
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// Session cookie settings
+	// +optional
+	Cookie *CookieSpec `json:"cookie,omitempty"`
+
+	// Authorization rules (resources). Resources in the default configuration are kept, unless
+	// a resource here has the same uri and methods, in which case it replaces them.
+	// +optional
+	// +listType=atomic
+	Resources []Resource `json:"resources,omitempty"`
+
+	// Strategy used to combine list and map options (e.g. resources, headers, match-claims) set in the
+	// default configuration with the ones managed by the operator, keyed by option name.
+	// Options default to Merge, except scopes which the typed field replaces by default.
+	// +optional
+	MergeStrategies map[string]MergeStrategy `json:"mergeStrategies,omitempty"`
+
+	// Do not restart workloads using this resource when the generated configuration changes
+	// +optional
+	DisableRollout bool `json:"disableRollout,omitempty"`
+
+	// Also restart workloads using this resource when the provider's signing keys change
+	// +optional
+	RolloutOnSigningKeyRotation bool `json:"rolloutOnSigningKeyRotation,omitempty"`
 }
 
+// Condition types reported in GogatekeeperStatus.Conditions
+const (
+	// ConditionConfigRendered indicates whether gatekeeper.yaml could be generated from the spec
+	ConditionConfigRendered = "ConfigRendered"
+	// ConditionConfigMapReady indicates whether the generated ConfigMap is up to date
+	ConditionConfigMapReady = "ConfigMapReady"
+	// ConditionProviderReachable indicates whether the OIDC provider could be reached
+	ConditionProviderReachable = "ProviderReachable"
+	// ConditionEncryptionKeyReady indicates whether the encryption key is set by the spec or could be generated
+	ConditionEncryptionKeyReady = "EncryptionKeyReady"
+	// ConditionCertificatesReady indicates whether the cert-manager Certificates of the workloads are up to date,
+	// it is only set while tls.certManager is used or unused Certificates are left to clean up
+	ConditionCertificatesReady = "CertificatesReady"
+)
+
 // GogatekeeperStatus defines the observed state of Gogatekeeper
 type GogatekeeperStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// Current state of the generated gatekeeper configuration
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// Most recent generation observed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Name of the generated ConfigMap holding gatekeeper.yaml
+	// +optional
+	ConfigMapName string `json:"configMapName,omitempty"`
+
+	// sha256 hash of the generated gatekeeper.yaml
+	// +optional
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// Name of the Secret holding the generated encryption key, when encryptionKeyRef is not set
+	// +optional
+	EncryptionKeySecret string `json:"encryptionKeySecret,omitempty"`
+
+	// Time the generated encryption key was last replaced
+	// +optional
+	LastRotated *metav1.Time `json:"lastRotated,omitempty"`
+
+	// Issuer advertised by the OIDC provider's discovery document at the last successful check
+	// +optional
+	Issuer string `json:"issuer,omitempty"`
+
+	// IDs of the signing keys published by the OIDC provider at the last successful check
+	// +optional
+	SigningKeyIDs []string `json:"signingKeyIDs,omitempty"`
+
+	// Time the OIDC provider was last checked
+	// +optional
+	LastProviderCheck *metav1.Time `json:"lastProviderCheck,omitempty"`
 }
 
+// ConfigFileKey is the key of the generated gatekeeper configuration in its ConfigMap
+const ConfigFileKey = "gatekeeper.yaml"
+
+// EncryptionKeySecretKey is the key of the generated encryption key in its Secret
+const EncryptionKeySecretKey = "encryption-key"
+
+// TLSMountPath is where the TLS certificate Secret is mounted in the gatekeeper container
+const TLSMountPath = "/etc/gatekeeper/tls"
+
+// UpstreamCAMountPath is where the upstream CA bundle is mounted in the gatekeeper container, as ca.crt
+const UpstreamCAMountPath = "/etc/gatekeeper/upstream-ca"
+
+// CertificateSecretName returns the name of the Secret holding the cert-manager issued certificate of a workload
+func CertificateSecretName(workloadName string) string {
+	return workloadName + "-gatekeeper-tls"
+}
+
+// PreviousEncryptionKeySecretKey is the key of the replaced encryption key during the rotation grace period
+const PreviousEncryptionKeySecretKey = "previous-encryption-key"
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="ConfigMap",type=string,JSONPath=`.status.configMapName`
+//+kubebuilder:printcolumn:name="Rendered",type=string,JSONPath=`.status.conditions[?(@.type=="ConfigRendered")].status`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="ConfigMapReady")].status`
+//+kubebuilder:printcolumn:name="Provider",type=string,JSONPath=`.status.conditions[?(@.type=="ProviderReachable")].status`
+//+kubebuilder:printcolumn:name="Hash",type=string,JSONPath=`.status.configHash`,priority=1
+//+kubebuilder:printcolumn:name="Generation",type=integer,JSONPath=`.status.observedGeneration`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Gogatekeeper is the Schema for the gogatekeepers API
 type Gogatekeeper struct {
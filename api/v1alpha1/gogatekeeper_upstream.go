@@ -0,0 +1,84 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// upstreamAnnotations are the gatekeeper.gogatekeeper/ annotation suffixes choosing the application port
+// upstream-url is detected from, they are not passed to gatekeeper
+var upstreamAnnotations = map[string]bool{
+	"upstream-port":      true,
+	"upstream-container": true,
+}
+
+// detectUpstreamURL returns the upstream-url of gatekeeper reaching the application in a pod over the loopback
+// interface. The port is chosen by the upstream-port annotation, a port number or the name of a container port,
+// among the ports of the upstream-container annotation's container, or of every container other than gatekeeper.
+// An error is returned when there is no port to choose or more than one.
+func detectUpstreamURL(pod *corev1.Pod, gatekeeperContainer string, annotations map[string]string) (string, error) {
+	containerName, portName := annotations["upstream-container"], annotations["upstream-port"]
+
+	containers := []corev1.Container{}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == gatekeeperContainer || (containerName != "" && container.Name != containerName) {
+			continue
+		}
+		containers = append(containers, container)
+	}
+	if containerName != "" && len(containers) == 0 {
+		return "", fmt.Errorf("upstream-container %s is not a container of the pod", containerName)
+	}
+
+	// A port number does not need to be declared by the container
+	if port, err := strconv.ParseInt(portName, 10, 32); err == nil {
+		if port < 1 || port > 65535 {
+			return "", fmt.Errorf("upstream-port %s must be between 1 and 65535", portName)
+		}
+		return upstreamURL(int32(port)), nil
+	}
+
+	ports := map[int32]bool{}
+	for _, container := range containers {
+		for _, port := range container.Ports {
+			if protocol(port) != corev1.ProtocolTCP || (portName != "" && port.Name != portName) {
+				continue
+			}
+			ports[port.ContainerPort] = true
+		}
+	}
+
+	switch {
+	case len(ports) == 1:
+		for port := range ports {
+			return upstreamURL(port), nil
+		}
+	case portName != "":
+		return "", fmt.Errorf("no single TCP container port is named upstream-port %s", portName)
+	case len(ports) == 0:
+		return "", fmt.Errorf("cannot detect the upstream, no TCP container ports are declared, set the gatekeeper.gogatekeeper/upstream-port annotation")
+	}
+	return "", fmt.Errorf("cannot detect the upstream, %d TCP container ports are declared, choose one with the gatekeeper.gogatekeeper/upstream-port or upstream-container annotation", len(ports))
+}
+
+func upstreamURL(port int32) string {
+	return "http://127.0.0.1:" + strconv.Itoa(int(port))
+}
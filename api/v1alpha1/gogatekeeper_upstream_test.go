@@ -0,0 +1,110 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestDetectUpstreamURL(t *testing.T) {
+	gatekeeper := corev1.Container{Name: "gogatekeeper", Ports: []corev1.ContainerPort{{Name: "gatekeeper", ContainerPort: 3000}}}
+	app := corev1.Container{Name: "app", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}}}
+	metrics := corev1.Container{Name: "metrics", Ports: []corev1.ContainerPort{
+		{Name: "metrics", ContainerPort: 9090},
+		{Name: "statsd", ContainerPort: 8125, Protocol: corev1.ProtocolUDP},
+	}}
+
+	tests := []struct {
+		name        string
+		containers  []corev1.Container
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name:       "single port",
+			containers: []corev1.Container{app, gatekeeper},
+			expected:   "http://127.0.0.1:8080",
+		},
+		{
+			name:       "ambiguous ports",
+			containers: []corev1.Container{app, metrics},
+		},
+		{
+			name:       "no ports",
+			containers: []corev1.Container{{Name: "app"}},
+		},
+		{
+			name:        "container",
+			containers:  []corev1.Container{app, metrics},
+			annotations: map[string]string{"upstream-container": "metrics"},
+			expected:    "http://127.0.0.1:9090",
+		},
+		{
+			name:        "missing container",
+			containers:  []corev1.Container{app},
+			annotations: map[string]string{"upstream-container": "web"},
+		},
+		{
+			name:        "port name",
+			containers:  []corev1.Container{app, metrics},
+			annotations: map[string]string{"upstream-port": "http"},
+			expected:    "http://127.0.0.1:8080",
+		},
+		{
+			name:        "port name of another container",
+			containers:  []corev1.Container{app, metrics},
+			annotations: map[string]string{"upstream-port": "http", "upstream-container": "metrics"},
+		},
+		{
+			name:        "udp port name",
+			containers:  []corev1.Container{metrics},
+			annotations: map[string]string{"upstream-port": "statsd"},
+		},
+		{
+			name:        "undeclared port number",
+			containers:  []corev1.Container{{Name: "app"}},
+			annotations: map[string]string{"upstream-port": "5000"},
+			expected:    "http://127.0.0.1:5000",
+		},
+		{
+			name:        "invalid port number",
+			containers:  []corev1.Container{app},
+			annotations: map[string]string{"upstream-port": "70000"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: test.containers}}
+			url, err := detectUpstreamURL(pod, gatekeeper.Name, test.annotations)
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("expected an error, got %s", url)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if url != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, url)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/validate-gatekeeper-theendbeta-me-v1alpha1-gogatekeeper,mutating=false,sideEffects=None,admissionReviewVersions=v1,failurePolicy=fail,groups=gatekeeper.theendbeta.me,resources=gogatekeepers,verbs=create;update,versions=v1alpha1,name=vgogatekeeper.kb.io
+
+// gogatekeeperValidator validates Gogatekeeper resources
+type gogatekeeperValidator struct {
+	decoder *admission.Decoder
+}
+
+// log is for logging in this package.
+var gogatekeeperValidatorLog = logf.Log.WithName("gogatekeeperValidator")
+
+func NewGogatekeeperValidator() admission.Handler {
+	return &gogatekeeperValidator{}
+}
+
+// Handle rejects Gogatekeepers that would generate an unusable gatekeeper configuration
+func (v *gogatekeeperValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	gk := &Gogatekeeper{}
+
+	err := v.decoder.Decode(req, gk)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	errs, warnings := gk.Validate()
+	if len(errs) > 0 {
+		gogatekeeperValidatorLog.Info("Rejecting invalid Gogatekeeper", "Name", gk.Name, "Namespace", req.Namespace, "Errors", errs.ToAggregate().Error())
+		invalid := apierrors.NewInvalid(GroupVersion.WithKind("Gogatekeeper").GroupKind(), gk.Name, errs)
+		return admission.Response{
+			AdmissionResponse: admissionv1.AdmissionResponse{
+				Allowed: false,
+				Result:  &invalid.ErrStatus,
+			},
+		}.WithWarnings(warnings...)
+	}
+
+	return admission.Allowed("").WithWarnings(warnings...)
+}
+
+// InjectDecoder injects the decoder.
+func (v *gogatekeeperValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// Validate checks the spec, returning errors for fields that can not be used and warnings for
+// fields that are likely mistakes
+func (gk *Gogatekeeper) Validate() (field.ErrorList, []string) {
+	errs := field.ErrorList{}
+	warnings := []string{}
+	specPath := field.NewPath("spec")
+
+	errs = append(errs, validateURL(specPath.Child("oidcurl"), gk.Spec.OIDCURL)...)
+	if gk.Spec.UpstreamURL != "" {
+		errs = append(errs, validateURL(specPath.Child("upstreamURL"), gk.Spec.UpstreamURL)...)
+	}
+	if gk.Spec.DetectUpstream && gk.Spec.UpstreamURL != "" {
+		errs = append(errs, field.Forbidden(specPath.Child("upstreamURL"), "must not be set when detectUpstream is true"))
+	}
+
+	if rotation := gk.Spec.EncryptionKeyRotation; rotation != nil {
+		rotationPath := specPath.Child("encryptionKeyRotation")
+		if gk.Spec.EncryptionKeyRef != nil {
+			errs = append(errs, field.Forbidden(rotationPath, "only the generated encryption key can be rotated, remove encryptionKeyRef"))
+		} else if !gk.GeneratesEncryptionKey() {
+			errs = append(errs, field.Forbidden(rotationPath, "only the generated encryption key can be rotated, remove encryption-key from defaultconfig"))
+		}
+		if rotation.Interval.Duration <= 0 {
+			errs = append(errs, field.Invalid(rotationPath.Child("interval"), rotation.Interval.Duration.String(), "must be greater than zero"))
+		}
+		if rotation.GracePeriod != nil && rotation.GracePeriod.Duration < 0 {
+			errs = append(errs, field.Invalid(rotationPath.Child("gracePeriod"), rotation.GracePeriod.Duration.String(), "must not be negative"))
+		}
+	}
+
+	if ca := gk.Spec.ProviderCA; ca != nil && (ca.ConfigMapKeyRef == nil) == (ca.SecretKeyRef == nil) {
+		errs = append(errs, field.Invalid(specPath.Child("providerCA"), "", "exactly one of configMapKeyRef and secretKeyRef must be set"))
+	}
+	if tls := gk.Spec.TLS; tls != nil && (tls.SecretName == "") == (tls.CertManager == nil) {
+		errs = append(errs, field.Invalid(specPath.Child("tls"), "", "exactly one of secretName and certManager must be set"))
+	}
+	// Resources are merged with the default configuration's by uri and the set of methods, which must identify them
+	resourceKeys := map[string]bool{}
+	for i, resource := range gk.Spec.Resources {
+		methodSet := map[string]bool{}
+		methods := []string{}
+		for _, method := range resource.Methods {
+			if !methodSet[string(method)] {
+				methodSet[string(method)] = true
+				methods = append(methods, string(method))
+			}
+		}
+		sort.Strings(methods)
+		key := resource.URI + " " + strings.Join(methods, ",")
+		if resourceKeys[key] {
+			errs = append(errs, field.Duplicate(specPath.Child("resources").Index(i), key))
+		}
+		resourceKeys[key] = true
+	}
+
+	if sidecar := gk.Spec.Sidecar; sidecar != nil && sidecar.LivenessProbe != nil {
+		if threshold := sidecar.LivenessProbe.SuccessThreshold; threshold != 0 && threshold != 1 {
+			errs = append(errs, field.Invalid(specPath.Child("sidecar", "livenessProbe", "successThreshold"), threshold, "must be 1"))
+		}
+	}
+	if skip := gk.Spec.SkipOpenIDProviderTLSVerify; skip != nil && *skip && gk.Spec.ProviderCA != nil {
+		warnings = append(warnings, fmt.Sprintf("%s is not used while %s is true", specPath.Child("providerCA"), specPath.Child("skipOpenIDProviderTLSVerify")))
+	}
+
+	defaultConfigPath := specPath.Child("defaultconfig")
+	options, err := defaultConfigOptions(gk.Spec.DefaultConfig)
+	if err != nil {
+		errs = append(errs, field.Invalid(defaultConfigPath, gk.Spec.DefaultConfig, err.Error()))
+		return errs, warnings
+	}
+
+	if upstream := gk.Spec.UpstreamTLS; upstream != nil && upstream.CASecretRef != nil {
+		upstreamTLSPath := specPath.Child("upstreamTLS")
+		upstreamURL, upstreamURLPath := gk.Spec.UpstreamURL, specPath.Child("upstreamURL")
+		if upstreamURL == "" {
+			upstreamURL, upstreamURLPath = defaultConfigString(gk.Spec.DefaultConfig, "upstream-url"), defaultConfigPath.Key("upstream-url")
+		}
+		if gk.Spec.DetectUpstream {
+			errs = append(errs, field.Invalid(specPath.Child("detectUpstream"), true, fmt.Sprintf("detected upstreams use http and cannot be used with %s", upstreamTLSPath)))
+		} else if upstreamURL == "" {
+			warnings = append(warnings, fmt.Sprintf("%s is only used with an https upstream-url, which is not set in the spec", upstreamTLSPath))
+		} else if u, err := url.Parse(upstreamURL); err == nil && u.Scheme != "https" {
+			errs = append(errs, field.Invalid(upstreamURLPath, upstreamURL, fmt.Sprintf("must be an https URL when %s is set", upstreamTLSPath)))
+		}
+	}
+
+	typedOptions := gk.Spec.typedOptions()
+	seen := map[string]bool{}
+	for _, option := range options {
+		if seen[option] {
+			warnings = append(warnings, fmt.Sprintf("%s: option %q is set more than once", defaultConfigPath, option))
+		}
+		seen[option] = true
+
+		if specField, ok := operatorOwnedOptions[option]; ok {
+			errs = append(errs, field.Forbidden(defaultConfigPath.Key(option), fmt.Sprintf("managed by the operator, set %s instead", specPath.Child(specField))))
+		} else if specField, ok := typedOptions[option]; ok {
+			warnings = append(warnings, fmt.Sprintf("%s: option %q is overridden by %s.%s", defaultConfigPath, option, specPath, specField))
+		} else if !knownGatekeeperOptions[option] {
+			warnings = append(warnings, fmt.Sprintf("%s: %q is not a known gatekeeper option", defaultConfigPath, option))
+		}
+	}
+
+	return errs, warnings
+}
+
+// validateURL checks that a URL is an absolute http(s) URL
+func validateURL(path *field.Path, value string) field.ErrorList {
+	u, err := url.Parse(value)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, value, err.Error())}
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return field.ErrorList{field.Invalid(path, value, "must be an absolute http or https URL")}
+	}
+	return nil
+}
+
+// defaultConfigOptions returns the top-level option names of a default configuration,
+// which must be empty or a yaml mapping
+func defaultConfigOptions(config string) ([]string, error) {
+	doc := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal([]byte(config), doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	mapping := doc.Content[0]
+	if mapping.Kind != yamlv3.MappingNode {
+		return nil, fmt.Errorf("must be a yaml mapping of gatekeeper options")
+	}
+
+	options := []string{}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		options = append(options, mapping.Content[i].Value)
+	}
+	return options, nil
+}
+
+// defaultConfigString returns the value of a string option in a default configuration,
+// or an empty string if it is not set
+func defaultConfigString(config, option string) string {
+	options := map[string]interface{}{}
+	if err := yamlv3.Unmarshal([]byte(config), &options); err != nil {
+		return ""
+	}
+	value, _ := options[option].(string)
+	return value
+}
@@ -0,0 +1,340 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     GogatekeeperSpec
+		errors   int
+		warnings int
+	}{
+		{
+			name: "valid",
+			spec: GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com/realms/test",
+				DefaultConfig: "upstream-url: http://127.0.0.1:80\nlisten: :3000\n",
+			},
+		},
+		{
+			name: "empty default config",
+			spec: GogatekeeperSpec{OIDCURL: "http://127.0.0.1:5556/dex"},
+		},
+		{
+			name:   "relative url",
+			spec:   GogatekeeperSpec{OIDCURL: "/dex"},
+			errors: 1,
+		},
+		{
+			name:   "non http url",
+			spec:   GogatekeeperSpec{OIDCURL: "ftp://idp.example.com"},
+			errors: 1,
+		},
+		{
+			name: "broken yaml",
+			spec: GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				DefaultConfig: "listen: [:3000\n",
+			},
+			errors: 1,
+		},
+		{
+			name: "non mapping yaml",
+			spec: GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				DefaultConfig: "- listen\n",
+			},
+			errors: 1,
+		},
+		{
+			name: "operator owned option",
+			spec: GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				DefaultConfig: "discovery-url: https://other.example.com\n",
+			},
+			errors: 1,
+		},
+		{
+			name: "unknown and duplicate options",
+			spec: GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				DefaultConfig: "listen: :3000\nlisten: :3001\nlisten-admn: :4000\n",
+			},
+			warnings: 2,
+		},
+		{
+			name: "typed option overrides default config",
+			spec: GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				UpstreamURL:   "http://127.0.0.1:8080",
+				DefaultConfig: "upstream-url: http://127.0.0.1:80\n",
+			},
+			warnings: 1,
+		},
+		{
+			name: "relative upstream url",
+			spec: GogatekeeperSpec{
+				OIDCURL:     "https://idp.example.com",
+				UpstreamURL: "127.0.0.1:8080",
+			},
+			errors: 1,
+		},
+		{
+			name: "encryption key rotation",
+			spec: GogatekeeperSpec{
+				OIDCURL:               "https://idp.example.com",
+				EncryptionKeyRotation: &EncryptionKeyRotation{Interval: metav1.Duration{Duration: 720 * time.Hour}},
+			},
+		},
+		{
+			name: "rotating a referenced encryption key",
+			spec: GogatekeeperSpec{
+				OIDCURL:               "https://idp.example.com",
+				EncryptionKeyRef:      &corev1.SecretKeySelector{Key: "key"},
+				EncryptionKeyRotation: &EncryptionKeyRotation{Interval: metav1.Duration{Duration: time.Hour}},
+			},
+			errors: 1,
+		},
+		{
+			name: "rotating an encryption key set in defaultconfig",
+			spec: GogatekeeperSpec{
+				OIDCURL:               "https://idp.example.com",
+				DefaultConfig:         "encryption-key: VtMkufTWXWA5x83C\n",
+				EncryptionKeyRotation: &EncryptionKeyRotation{Interval: metav1.Duration{Duration: time.Hour}},
+			},
+			errors: 1,
+		},
+		{
+			name: "invalid rotation durations",
+			spec: GogatekeeperSpec{
+				OIDCURL: "https://idp.example.com",
+				EncryptionKeyRotation: &EncryptionKeyRotation{
+					GracePeriod: &metav1.Duration{Duration: -time.Hour},
+				},
+			},
+			errors: 2,
+		},
+		{
+			name: "provider ca",
+			spec: GogatekeeperSpec{
+				OIDCURL:    "https://idp.example.com",
+				ProviderCA: &CABundleSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "ca.crt"}},
+			},
+		},
+		{
+			name: "provider ca from two sources",
+			spec: GogatekeeperSpec{
+				OIDCURL: "https://idp.example.com",
+				ProviderCA: &CABundleSource{
+					ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "ca.crt"},
+					SecretKeyRef:    &corev1.SecretKeySelector{Key: "ca.crt"},
+				},
+			},
+			errors: 1,
+		},
+		{
+			name: "tls from cert-manager",
+			spec: GogatekeeperSpec{
+				OIDCURL: "https://idp.example.com",
+				TLS:     &TLSSpec{CertManager: &CertManagerTLS{IssuerRef: IssuerReference{Name: "ca-issuer"}}},
+			},
+		},
+		{
+			name: "tls without a source",
+			spec: GogatekeeperSpec{
+				OIDCURL: "https://idp.example.com",
+				TLS:     &TLSSpec{},
+			},
+			errors: 1,
+		},
+		{
+			name: "tls overrides default config",
+			spec: GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				DefaultConfig: "tls-cert: /certs/tls.crt\n",
+				TLS:           &TLSSpec{SecretName: "gatekeeper-tls"},
+			},
+			warnings: 1,
+		},
+		{
+			name: "upstream tls",
+			spec: GogatekeeperSpec{
+				OIDCURL:     "https://idp.example.com",
+				UpstreamURL: "https://127.0.0.1:8443",
+				UpstreamTLS: &UpstreamTLSSpec{CASecretRef: &corev1.SecretKeySelector{Key: "ca.crt"}},
+			},
+		},
+		{
+			name: "upstream tls with http upstream",
+			spec: GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				DefaultConfig: "upstream-url: http://127.0.0.1:8080\n",
+				UpstreamTLS:   &UpstreamTLSSpec{CASecretRef: &corev1.SecretKeySelector{Key: "ca.crt"}},
+			},
+			errors: 1,
+		},
+		{
+			name: "upstream tls without upstream",
+			spec: GogatekeeperSpec{
+				OIDCURL:     "https://idp.example.com",
+				UpstreamTLS: &UpstreamTLSSpec{CASecretRef: &corev1.SecretKeySelector{Key: "ca.crt"}},
+			},
+			warnings: 1,
+		},
+		{
+			name: "detected upstream",
+			spec: GogatekeeperSpec{
+				OIDCURL:        "https://idp.example.com",
+				DefaultConfig:  "upstream-url: http://127.0.0.1:80\n",
+				DetectUpstream: true,
+			},
+			warnings: 1,
+		},
+		{
+			name: "detected upstream with upstream url",
+			spec: GogatekeeperSpec{
+				OIDCURL:        "https://idp.example.com",
+				UpstreamURL:    "http://127.0.0.1:8080",
+				DetectUpstream: true,
+			},
+			errors: 1,
+		},
+		{
+			name: "detected upstream with upstream tls",
+			spec: GogatekeeperSpec{
+				OIDCURL:        "https://idp.example.com",
+				DetectUpstream: true,
+				UpstreamTLS:    &UpstreamTLSSpec{CASecretRef: &corev1.SecretKeySelector{Key: "ca.crt"}},
+			},
+			errors: 1,
+		},
+		{
+			name: "resources split by method",
+			spec: GogatekeeperSpec{
+				OIDCURL: "https://idp.example.com",
+				Resources: []Resource{
+					{URI: "/admin*", Methods: []HTTPMethod{"GET"}, Roles: []string{"reader"}},
+					{URI: "/admin*", Methods: []HTTPMethod{"POST"}, Roles: []string{"writer"}},
+					{URI: "/admin*", Roles: []string{"admin"}},
+				},
+			},
+		},
+		{
+			name: "duplicate resources",
+			spec: GogatekeeperSpec{
+				OIDCURL: "https://idp.example.com",
+				Resources: []Resource{
+					{URI: "/admin*", Methods: []HTTPMethod{"GET"}, Roles: []string{"reader"}},
+					{URI: "/admin*", Methods: []HTTPMethod{"GET"}, Roles: []string{"writer"}},
+				},
+			},
+			errors: 1,
+		},
+		{
+			name: "duplicate resources with reordered methods",
+			spec: GogatekeeperSpec{
+				OIDCURL: "https://idp.example.com",
+				Resources: []Resource{
+					{URI: "/admin*", Methods: []HTTPMethod{"GET", "POST"}, Roles: []string{"reader"}},
+					{URI: "/admin*", Methods: []HTTPMethod{"POST", "GET"}, Roles: []string{"writer"}},
+				},
+			},
+			errors: 1,
+		},
+		{
+			name: "liveness probe success threshold",
+			spec: GogatekeeperSpec{
+				OIDCURL: "https://idp.example.com",
+				Sidecar: &SidecarSpec{
+					LivenessProbe:  &ProbeThresholds{SuccessThreshold: 2},
+					ReadinessProbe: &ProbeThresholds{SuccessThreshold: 2},
+				},
+			},
+			errors: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gk := &Gogatekeeper{Spec: test.spec}
+			errs, warnings := gk.Validate()
+			if len(errs) != test.errors {
+				t.Errorf("expected %d errors, got %v", test.errors, errs)
+			}
+			if len(warnings) != test.warnings {
+				t.Errorf("expected %d warnings, got %v", test.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestValidatorHandle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := NewGogatekeeperValidator().(*gogatekeeperValidator)
+	if err := validator.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		spec     GogatekeeperSpec
+		allowed  bool
+		warnings int
+	}{
+		{name: "valid", spec: GogatekeeperSpec{OIDCURL: "https://idp.example.com"}, allowed: true},
+		{name: "warnings", spec: GogatekeeperSpec{OIDCURL: "https://idp.example.com", DefaultConfig: "no-such-option: true\n"}, allowed: true, warnings: 1},
+		{name: "invalid", spec: GogatekeeperSpec{OIDCURL: "idp.example.com"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gk := &Gogatekeeper{
+				TypeMeta:   metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: "Gogatekeeper"},
+				ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps"},
+				Spec:       test.spec,
+			}
+			response := validator.Handle(context.Background(), admissionRequest(t, admissionv1.Create, gk))
+			if response.Allowed != test.allowed {
+				t.Errorf("expected allowed %v, got %+v", test.allowed, response.Result)
+			}
+			if !test.allowed && (response.Result == nil || response.Result.Reason != metav1.StatusReasonInvalid) {
+				t.Errorf("expected an Invalid status, got %+v", response.Result)
+			}
+			if len(response.Warnings) != test.warnings {
+				t.Errorf("expected %d warnings, got %v", test.warnings, response.Warnings)
+			}
+		})
+	}
+}
@@ -18,11 +18,18 @@ package v1alpha1
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
 
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -31,23 +38,56 @@ import (
 var gkAnnotationPrefix = "gatekeeper.gogatekeeper"
 var gkAnnotation = regexp.MustCompile(`^gatekeeper.gogatekeeper/?(.*)$`)
 
-// +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,sideEffects=noneOnDryRun,admissionReviewVersions=v1,failurePolicy=fail,groups="",resources=pods,verbs=create;update,versions=v1,name=mpod.kb.io
+// gatekeeperContainerName is the name of the injected gatekeeper container
+const gatekeeperContainerName = "gogatekeeper"
+
+// MissingGogatekeeperPolicyLabel is the namespace label selecting how pods requesting a Gogatekeeper that is
+// missing or not ready are handled: MissingGogatekeeperReject (the default) or MissingGogatekeeperWarn
+const MissingGogatekeeperPolicyLabel = "gatekeeper.theendbeta.me/missing-gogatekeeper"
+
+const (
+	// MissingGogatekeeperReject rejects the pod
+	MissingGogatekeeperReject = "reject"
+	// MissingGogatekeeperWarn injects the pod anyway, returning a warning
+	MissingGogatekeeperWarn = "warn"
+)
+
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+// +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,sideEffects=noneOnDryRun,admissionReviewVersions=v1,failurePolicy=fail,groups="",resources=pods,verbs=create,versions=v1,name=mpod.kb.io
+
+// DefaultGatekeeperImage is the gatekeeper image injected when neither the operator nor the Gogatekeeper set one
+const DefaultGatekeeperImage = "quay.io/gogatekeeper/gatekeeper:1.3.4"
+
+// InjectorOptions are the operator-wide defaults of injected gatekeeper containers
+type InjectorOptions struct {
+	// Image injected when a Gogatekeeper does not set spec.image, DefaultGatekeeperImage when empty
+	Image string
+	// Resources of the gatekeeper container, overridden per resource by Gogatekeepers and pods
+	Resources corev1.ResourceRequirements
+}
 
 // gatekeeperInjector injects sidecars
 type gatekeeperInjector struct {
 	Client  client.Client
+	Options InjectorOptions
 	decoder *admission.Decoder
 }
 
 // log is for logging in this package.
 var gatekeeperInjectorLog = logf.Log.WithName("gatekeeperInjector")
 
-func NewGatekeeperInjector(c client.Client) admission.Handler {
-	return &gatekeeperInjector{Client: c}
+func NewGatekeeperInjector(c client.Client, options InjectorOptions) admission.Handler {
+	return &gatekeeperInjector{Client: c, Options: options}
 }
 
 // gatekeeperInjector adds an annotation to every incoming pods.
 func (a *gatekeeperInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	// The containers of an existing pod can not be changed, so they are only injected on creation
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("Only created pods are injected")
+	}
+
 	pod := &corev1.Pod{}
 
 	err := a.decoder.Decode(req, pod)
@@ -61,12 +101,36 @@ func (a *gatekeeperInjector) Handle(ctx context.Context, req admission.Request)
 		return admission.Allowed("No injection requested")
 	}
 
-	gatekeeperInjectorLog.Info("Injecting gatekeeper container", "Pod", pod.Name, "ConfigMap", podAnnotations["gatekeeper.gogatekeeper"])
+	gkName := podAnnotations[gkAnnotationPrefix]
+	gatekeeperInjectorLog.Info("Injecting gatekeeper container", "Pod", pod.Name, "Gogatekeeper", gkName)
+
+	// Look up the Gogatekeeper for the configuration and the settings applied to every instance using it
+	gk, problem, err := a.resolveGogatekeeper(ctx, req.Namespace, gkName)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	warnings := []string{}
+	configMapName := gkName
+	if gk != nil && gk.Status.ConfigMapName != "" {
+		configMapName = gk.Status.ConfigMapName
+	}
+	if problem != "" {
+		policy, err := a.missingPolicy(ctx, req.Namespace)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if policy != MissingGogatekeeperWarn {
+			gatekeeperInjectorLog.Info("Rejecting pod using an unusable Gogatekeeper", "Pod", pod.Name, "Gogatekeeper", gkName, "Problem", problem)
+			return admission.Denied(problem)
+		}
+		gatekeeperInjectorLog.Info("Injecting pod using an unusable Gogatekeeper", "Pod", pod.Name, "Gogatekeeper", gkName, "Problem", problem)
+		warnings = append(warnings, problem+", gatekeeper may fail to start")
+	}
 
 	// Mount ConfigFile (CRD generated) as config for gatekeeper instance
 	configMapVolume := &corev1.ConfigMapVolumeSource{
 		LocalObjectReference: corev1.LocalObjectReference{
-			Name: podAnnotations[gkAnnotationPrefix],
+			Name: configMapName,
 		},
 	}
 	gatekeeperConfigVolume := corev1.Volume{
@@ -83,61 +147,378 @@ func (a *gatekeeperInjector) Handle(ctx context.Context, req admission.Request)
 	}
 
 	envFromSource := []corev1.EnvFromSource{}
+	resourceOverrides := map[string]string{}
+	upstreamOverrides := map[string]string{}
+	argOverrides := map[string]string{}
 
 	// Parse additional annotations:
 	// `gatekeeper.gogatekeeper/existingEnv: val`       -- load ConfigMap "val" as `envFrom` in container
 	// `gatekeeper.gogatekeeper/existingSecretEnv: val` -- load Secret "val" as `envFrom` in container
+	// `gatekeeper.gogatekeeper/cpu-request: val`       -- override the container's resources, also `cpu-limit`,
+	//                                                     `memory-request` and `memory-limit`
+	// `gatekeeper.gogatekeeper/upstream-port: val`     -- detect `--upstream-url` from port "val" of the pod, also
+	//                                                     `upstream-container`
 	// `gatekeeper.gogatekeeper/my-cli-option: val`     -- set `--my-cli-option=val` as arg to container
-	for annot, val := range podAnnotations {
+	// Annotations are handled in a fixed order, so that re-invocations produce the same container
+	annotationKeys := make([]string, 0, len(podAnnotations))
+	for annot := range podAnnotations {
+		annotationKeys = append(annotationKeys, annot)
+	}
+	sort.Strings(annotationKeys)
+	for _, annot := range annotationKeys {
+		val := podAnnotations[annot]
 		matches := gkAnnotation.FindStringSubmatch(annot)
 		if matches != nil && matches[1] != "" {
-			switch matches[1] {
-			case "existingEnv":
+			option := matches[1]
+			_, isResource := resourceAnnotations[option]
+			switch {
+			case option == "existingEnv":
 				envFromSource = append(envFromSource, corev1.EnvFromSource{
 					ConfigMapRef: &corev1.ConfigMapEnvSource{
 						LocalObjectReference: corev1.LocalObjectReference{Name: val},
 					},
 				})
-			case "existingSecretEnv":
+			case option == "existingSecretEnv":
 				envFromSource = append(envFromSource, corev1.EnvFromSource{
 					SecretRef: &corev1.SecretEnvSource{
 						LocalObjectReference: corev1.LocalObjectReference{Name: val},
 					},
 				})
+			case isResource:
+				resourceOverrides[option] = val
+			case upstreamAnnotations[option]:
+				upstreamOverrides[option] = val
 			default:
-				gkContainerArgs = append(gkContainerArgs, "--"+matches[1], val)
+				gkContainerArgs = append(gkContainerArgs, "--"+option, val)
+				argOverrides[option] = val
 			}
 		}
 	}
 
+	// Point gatekeeper at the application's port, unless the pod sets upstream-url itself
+	if (gk != nil && gk.Spec.DetectUpstream) || len(upstreamOverrides) > 0 {
+		if _, ok := argOverrides["upstream-url"]; ok {
+			if len(upstreamOverrides) > 0 {
+				return admission.Denied("the upstream-url annotation cannot be combined with upstream-port or upstream-container")
+			}
+		} else {
+			detected, err := detectUpstreamURL(pod, gatekeeperContainerName, upstreamOverrides)
+			if err != nil {
+				return admission.Denied(err.Error())
+			}
+			gkContainerArgs = append(gkContainerArgs, "--upstream-url", detected)
+		}
+	}
+
+	// A key the pod brings itself must not be shadowed by the generated one, as env takes precedence over envFrom
+	ownKey, err := a.bringsEncryptionKey(ctx, req.Namespace, podAnnotations)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	resources, err := a.sidecarResources(gk, resourceOverrides)
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	// Declare the ports and probe the health endpoint of the listeners in the effective configuration,
+	// the generated one with the pod's argument overrides
+	config := ""
+	if problem == "" {
+		config, err = a.renderedConfig(ctx, req.Namespace, configMapName)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+	}
+	listeners, err := parseListeners(config)
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	listeners.override(argOverrides)
+	ports, err := listeners.containerPorts()
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	healthCheck, err := listeners.healthCheck()
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	livenessProbe, readinessProbe := sidecarProbes(gk, healthCheck)
+
 	gatekeeperContainer := corev1.Container{
-		Image: "quay.io/gogatekeeper/gatekeeper:1.3.4",
-		Name:  "gogatekeeper",
+		Image:           a.image(gk),
+		ImagePullPolicy: imagePullPolicy(gk),
+		Name:            gatekeeperContainerName,
 		VolumeMounts: []corev1.VolumeMount{
 			{
 				Name:      "gatekeeper-config",
 				MountPath: "/etc/gatekeeperConfig/",
 			},
 		},
-		EnvFrom: envFromSource,
-		Ports: []corev1.ContainerPort{
-			{
-				Name:          "gatekeeper",
-				ContainerPort: 3000,
-			},
+		EnvFrom:         envFromSource,
+		Env:             credentialEnv(gk, ownKey),
+		Ports:           ports,
+		Args:            gkContainerArgs,
+		Resources:       resources,
+		LivenessProbe:   livenessProbe,
+		ReadinessProbe:  readinessProbe,
+		SecurityContext: sidecarSecurityContext(gk),
+	}
+
+	inj := &injection{
+		container:   gatekeeperContainer,
+		volumes:     []corev1.Volume{gatekeeperConfigVolume},
+		annotations: map[string]string{injectedAnnotation: gkName},
+	}
+	if gk != nil {
+		inj.imagePullSecrets = gk.Spec.ImagePullSecrets
+	}
+	mountProviderCA(gk, inj)
+	mountUpstreamTLS(gk, inj)
+	if err := mountTLS(gk, pod, inj); err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	// A pod carrying the marker annotation is being re-invoked (reinvocationPolicy: IfNeeded), and has its
+	// gatekeeper container and volumes replaced in place rather than added a second time
+	if _, injected := podAnnotations[injectedAnnotation]; !injected {
+		if err := inj.conflicts(pod); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+	if err := inj.portConflicts(pod); err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	// Only add to the pod as received, rather than re-encoding it, which would drop any fields
+	// unknown to the vendored API types
+	return admission.Patched("Injected gatekeeper container", inj.patches(pod)...).WithWarnings(warnings...)
+}
+
+// resolveGogatekeeper looks up the Gogatekeeper named by a pod. When it is missing, or its configuration has not
+// been generated yet, the problem is described in the returned string.
+func (a *gatekeeperInjector) resolveGogatekeeper(ctx context.Context, namespace, name string) (*Gogatekeeper, string, error) {
+	gk := &Gogatekeeper{}
+	if err := a.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, gk); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Sprintf("Gogatekeeper %s not found in namespace %s", name, namespace), nil
+		}
+		return nil, "", err
+	}
+
+	// A ConfigMap that is not ready because the spec stopped rendering still holds the last good configuration,
+	// which pods keep being injected with until the spec is fixed
+	if gk.Status.ConfigMapName == "" {
+		return gk, fmt.Sprintf("Gogatekeeper %s is not ready, its configuration has not been generated", name), nil
+	}
+	return gk, "", nil
+}
+
+// renderedConfig returns the gatekeeper configuration generated for a Gogatekeeper, or an empty configuration
+// if its ConfigMap does not exist
+func (a *gatekeeperInjector) renderedConfig(ctx context.Context, namespace, configMapName string) (string, error) {
+	configMap := &corev1.ConfigMap{}
+	if err := a.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: configMapName}, configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return configMap.Data[ConfigFileKey], nil
+}
+
+// missingPolicy returns how pods using a missing or unready Gogatekeeper are handled in a namespace
+func (a *gatekeeperInjector) missingPolicy(ctx context.Context, namespace string) (string, error) {
+	ns := &corev1.Namespace{}
+	if err := a.Client.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return "", err
+	}
+	if ns.Labels[MissingGogatekeeperPolicyLabel] == MissingGogatekeeperWarn {
+		return MissingGogatekeeperWarn, nil
+	}
+	return MissingGogatekeeperReject, nil
+}
+
+// image returns the gatekeeper image to inject, from the Gogatekeeper or else the operator's default
+func (a *gatekeeperInjector) image(gk *Gogatekeeper) string {
+	if gk != nil && gk.Spec.Image != "" {
+		return gk.Spec.Image
+	}
+	if a.Options.Image != "" {
+		return a.Options.Image
+	}
+	return DefaultGatekeeperImage
+}
+
+func imagePullPolicy(gk *Gogatekeeper) corev1.PullPolicy {
+	if gk == nil {
+		return ""
+	}
+	return gk.Spec.ImagePullPolicy
+}
+
+// bringsEncryptionKey reports whether a pod sets gatekeeper's encryption key itself, through the encryption-key
+// annotation or PROXY_ENCRYPTION_KEY in its existingSecretEnv Secret or existingEnv ConfigMap
+func (a *gatekeeperInjector) bringsEncryptionKey(ctx context.Context, namespace string, annotations map[string]string) (bool, error) {
+	if _, ok := annotations[gkAnnotationPrefix+"/encryption-key"]; ok {
+		return true, nil
+	}
+
+	if name := annotations[gkAnnotationPrefix+"/existingSecretEnv"]; name != "" {
+		secret := &corev1.Secret{}
+		if err := a.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil && !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		if _, ok := secret.Data[encryptionKeyEnv]; ok {
+			return true, nil
+		}
+	}
+	if name := annotations[gkAnnotationPrefix+"/existingEnv"]; name != "" {
+		configMap := &corev1.ConfigMap{}
+		if err := a.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, configMap); err != nil && !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		if _, ok := configMap.Data[encryptionKeyEnv]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// encryptionKeyEnv is the environment variable gatekeeper reads its encryption key from
+const encryptionKeyEnv = "PROXY_ENCRYPTION_KEY"
+
+// credentialEnv references the credentials configured on the Gogatekeeper as gatekeeper environment variables.
+// The generated encryption key is left out when the pod brings its own key.
+func credentialEnv(gk *Gogatekeeper, ownKey bool) []corev1.EnvVar {
+	env := []corev1.EnvVar{}
+	if gk == nil {
+		return env
+	}
+
+	if ref := gk.Spec.ClientSecretRef; ref != nil {
+		env = append(env, corev1.EnvVar{
+			Name:      "PROXY_CLIENT_SECRET",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: ref.DeepCopy()},
+		})
+	}
+	if ref := gk.Spec.EncryptionKeyRef; ref != nil {
+		env = append(env, corev1.EnvVar{
+			Name:      encryptionKeyEnv,
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: ref.DeepCopy()},
+		})
+	} else if gk.Status.EncryptionKeySecret != "" && gk.GeneratesEncryptionKey() && !ownKey {
+		env = append(env, corev1.EnvVar{
+			Name: encryptionKeyEnv,
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: gk.Status.EncryptionKeySecret},
+				Key:                  EncryptionKeySecretKey,
+			}},
+		})
+	}
+	return env
+}
+
+// providerCADir is where the provider CA bundle is mounted in the gatekeeper container
+const providerCADir = "/etc/gatekeeper/provider-ca"
+
+// mountProviderCA mounts the Gogatekeeper's provider CA bundle into the gatekeeper container.
+// gatekeeper has no option for the provider's CA, so it is added to the directories searched
+// for system CAs through SSL_CERT_DIR.
+func mountProviderCA(gk *Gogatekeeper, inj *injection) {
+	if gk == nil || gk.Spec.ProviderCA == nil {
+		return
+	}
+
+	volume := corev1.Volume{Name: "gatekeeper-provider-ca"}
+	if ref := gk.Spec.ProviderCA.ConfigMapKeyRef; ref != nil {
+		volume.ConfigMap = &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: ref.LocalObjectReference,
+			Items:                []corev1.KeyToPath{{Key: ref.Key, Path: "ca.crt"}},
+			Optional:             ref.Optional,
+		}
+	} else if ref := gk.Spec.ProviderCA.SecretKeyRef; ref != nil {
+		volume.Secret = &corev1.SecretVolumeSource{
+			SecretName: ref.Name,
+			Items:      []corev1.KeyToPath{{Key: ref.Key, Path: "ca.crt"}},
+			Optional:   ref.Optional,
+		}
+	} else {
+		return
+	}
+
+	inj.mount(volume, providerCADir)
+	inj.container.Env = append(inj.container.Env, corev1.EnvVar{
+		Name:  "SSL_CERT_DIR",
+		Value: providerCADir + ":/etc/ssl/certs",
+	})
+}
+
+// mountTLS mounts the certificate of gatekeeper's HTTPS listener into the gatekeeper container,
+// at the paths set as tls-cert and tls-private-key in the generated configuration
+func mountTLS(gk *Gogatekeeper, pod *corev1.Pod, inj *injection) error {
+	if gk == nil || gk.Spec.TLS == nil {
+		return nil
+	}
+
+	secretName := gk.Spec.TLS.SecretName
+	if gk.Spec.TLS.CertManager != nil {
+		workloadName := podWorkloadName(pod)
+		if workloadName == "" {
+			return fmt.Errorf("Gogatekeeper %s issues a certificate per workload, pods must be created by a Deployment, StatefulSet or DaemonSet", gk.Name)
+		}
+		secretName = CertificateSecretName(workloadName)
+	}
+
+	inj.mount(corev1.Volume{
+		Name: "gatekeeper-tls",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: secretName},
 		},
-		Args: gkContainerArgs,
+	}, TLSMountPath)
+	return nil
+}
+
+// mountUpstreamTLS mounts the upstream CA bundle into the gatekeeper container,
+// at the path set as upstream-ca in the generated configuration
+func mountUpstreamTLS(gk *Gogatekeeper, inj *injection) {
+	if gk == nil || gk.Spec.UpstreamTLS == nil {
+		return
 	}
 
-	pod.Spec.Containers = append(pod.Spec.Containers, gatekeeperContainer)
-	pod.Spec.Volumes = append(pod.Spec.Volumes, gatekeeperConfigVolume)
+	if ref := gk.Spec.UpstreamTLS.CASecretRef; ref != nil {
+		inj.mount(corev1.Volume{
+			Name: "gatekeeper-upstream-ca",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: ref.Name,
+					Items:      []corev1.KeyToPath{{Key: ref.Key, Path: "ca.crt"}},
+					Optional:   ref.Optional,
+				},
+			},
+		}, UpstreamCAMountPath)
+	}
+}
 
-	marshaledPod, err := json.Marshal(pod)
-	if err != nil {
-		return admission.Errored(http.StatusInternalServerError, err)
+// podWorkloadName returns the name of the Deployment, StatefulSet or DaemonSet controlling a pod,
+// or an empty string if it has none
+func podWorkloadName(pod *corev1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return ""
 	}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	switch owner.Kind {
+	case "StatefulSet", "DaemonSet":
+		return owner.Name
+	case "ReplicaSet":
+		// Deployments name their ReplicaSets <deployment>-<pod-template-hash>
+		hash := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+		if hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+			return strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	}
+	return ""
 }
 
 // gatekeeperInjector implements admission.DecoderInjector.
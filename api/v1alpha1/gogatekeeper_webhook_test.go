@@ -0,0 +1,562 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestPodWorkloadName(t *testing.T) {
+	controller := true
+	owned := func(kind, name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Labels:          labels,
+			OwnerReferences: []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		expected string
+	}{
+		{
+			name:     "deployment",
+			pod:      owned("ReplicaSet", "web-5d4f8c7b9", map[string]string{"pod-template-hash": "5d4f8c7b9"}),
+			expected: "web",
+		},
+		{
+			name: "bare replicaset",
+			pod:  owned("ReplicaSet", "web", nil),
+		},
+		{
+			name:     "statefulset",
+			pod:      owned("StatefulSet", "db", nil),
+			expected: "db",
+		},
+		{
+			name: "job",
+			pod:  owned("Job", "migrate", nil),
+		},
+		{
+			name: "bare pod",
+			pod:  &corev1.Pod{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if name := podWorkloadName(test.pod); name != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
+
+func TestInjectionPatches(t *testing.T) {
+	inj := &injection{
+		container:   corev1.Container{Name: "gogatekeeper"},
+		volumes:     []corev1.Volume{{Name: "gatekeeper-config"}, {Name: "gatekeeper-tls"}},
+		annotations: map[string]string{injectedAnnotation: "gatekeeper-test"},
+	}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		expected []string
+	}{
+		{
+			name: "pod without volumes or annotations",
+			pod:  &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}},
+			expected: []string{
+				"add /spec/containers/-",
+				"add /spec/volumes",
+				"add /spec/volumes/-",
+				"add /metadata/annotations",
+				"add /metadata/annotations/gatekeeper.theendbeta.me~1injected",
+			},
+		},
+		{
+			name: "pod with volumes and annotations",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"gatekeeper.gogatekeeper": "gatekeeper-test"}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app"}},
+					Volumes:    []corev1.Volume{{Name: "data"}},
+				},
+			},
+			expected: []string{
+				"add /spec/containers/-",
+				"add /spec/volumes/-",
+				"add /spec/volumes/-",
+				"add /metadata/annotations/gatekeeper.theendbeta.me~1injected",
+			},
+		},
+		{
+			name: "re-invoked on an injected pod",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{injectedAnnotation: "gatekeeper-test"}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app"}, {Name: "gogatekeeper"}},
+					Volumes:    []corev1.Volume{{Name: "data"}, {Name: "gatekeeper-config"}},
+				},
+			},
+			expected: []string{
+				"replace /spec/containers/1",
+				"replace /spec/volumes/1",
+				"add /spec/volumes/-",
+				"add /metadata/annotations/gatekeeper.theendbeta.me~1injected",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths := []string{}
+			for _, patch := range inj.patches(test.pod) {
+				paths = append(paths, patch.Operation+" "+patch.Path)
+			}
+			if !reflect.DeepEqual(paths, test.expected) {
+				t.Errorf("expected patches to %v, got %v", test.expected, paths)
+			}
+		})
+	}
+}
+
+func TestInjectionConflicts(t *testing.T) {
+	inj := &injection{
+		container: corev1.Container{Name: "gogatekeeper"},
+		volumes:   []corev1.Volume{{Name: "gatekeeper-config"}},
+	}
+
+	if err := inj.conflicts(&corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}); err != nil {
+		t.Errorf("unexpected conflict: %v", err)
+	}
+	if err := inj.conflicts(&corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "gogatekeeper"}}}}); err == nil {
+		t.Error("expected a container conflict")
+	}
+	if err := inj.conflicts(&corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "gatekeeper-config"}}}}); err == nil {
+		t.Error("expected a volume conflict")
+	}
+}
+
+func TestInjectionPortConflicts(t *testing.T) {
+	inj := &injection{container: corev1.Container{
+		Name:  "gogatekeeper",
+		Ports: []corev1.ContainerPort{{Name: "gatekeeper", ContainerPort: 3000}, {Name: "gatekeeper-admin", ContainerPort: 4000}},
+	}}
+
+	tests := []struct {
+		name     string
+		ports    []corev1.ContainerPort
+		conflict bool
+	}{
+		{name: "other port", ports: []corev1.ContainerPort{{ContainerPort: 8080}}},
+		{name: "udp port", ports: []corev1.ContainerPort{{ContainerPort: 3000, Protocol: corev1.ProtocolUDP}}},
+		{name: "proxy port", ports: []corev1.ContainerPort{{ContainerPort: 3000}}, conflict: true},
+		{name: "admin port", ports: []corev1.ContainerPort{{ContainerPort: 4000, Protocol: corev1.ProtocolTCP}}, conflict: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app", Ports: test.ports},
+				*inj.container.DeepCopy(),
+			}}}
+			err := inj.portConflicts(pod)
+			if test.conflict && err == nil {
+				t.Error("expected a port conflict")
+			}
+			if !test.conflict && err != nil {
+				t.Errorf("unexpected conflict: %v", err)
+			}
+		})
+	}
+}
+
+func TestResolveGogatekeeper(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	ready := &Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "ready", Namespace: "apps"},
+		Status: GogatekeeperStatus{
+			ConfigMapName: "ready-config",
+			Conditions:    []metav1.Condition{{Type: ConditionConfigMapReady, Status: metav1.ConditionTrue}},
+		},
+	}
+	stale := &Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "stale", Namespace: "apps"},
+		Status: GogatekeeperStatus{
+			ConfigMapName: "stale",
+			Conditions: []metav1.Condition{
+				{Type: ConditionConfigRendered, Status: metav1.ConditionFalse, Reason: "InvalidDefaultConfig"},
+				{Type: ConditionConfigMapReady, Status: metav1.ConditionFalse, Reason: "Stale"},
+			},
+		},
+	}
+	pending := &Gogatekeeper{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "apps"}}
+	warnNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "apps",
+		Labels: map[string]string{MissingGogatekeeperPolicyLabel: MissingGogatekeeperWarn},
+	}}
+	injector := &gatekeeperInjector{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(ready, stale, pending, warnNamespace).Build()}
+
+	tests := []struct {
+		name    string
+		problem bool
+		found   bool
+	}{
+		{name: "ready", found: true},
+		{name: "stale", found: true},
+		{name: "pending", found: true, problem: true},
+		{name: "missing", problem: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gk, problem, err := injector.resolveGogatekeeper(context.Background(), "apps", test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (gk != nil) != test.found {
+				t.Errorf("expected found %v, got %v", test.found, gk)
+			}
+			if (problem != "") != test.problem {
+				t.Errorf("expected problem %v, got %q", test.problem, problem)
+			}
+		})
+	}
+
+	policy, err := injector.missingPolicy(context.Background(), "apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy != MissingGogatekeeperWarn {
+		t.Errorf("expected %q policy, got %q", MissingGogatekeeperWarn, policy)
+	}
+}
+
+func TestInjectionPullSecrets(t *testing.T) {
+	inj := &injection{
+		container:        corev1.Container{Name: "gogatekeeper"},
+		imagePullSecrets: []corev1.LocalObjectReference{{Name: "mirror"}, {Name: "quay"}},
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		Containers:       []corev1.Container{{Name: "app"}},
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "mirror"}},
+	}}
+
+	paths := []string{}
+	for _, patch := range inj.patches(pod) {
+		paths = append(paths, patch.Operation+" "+patch.Path)
+	}
+	expected := []string{"add /spec/containers/-", "add /spec/imagePullSecrets/-"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected patches to %v, got %v", expected, paths)
+	}
+}
+
+func TestInjectorImage(t *testing.T) {
+	custom := &Gogatekeeper{Spec: GogatekeeperSpec{Image: "mirror.example.com/gatekeeper:1.3.5"}}
+
+	tests := []struct {
+		name     string
+		options  InjectorOptions
+		gk       *Gogatekeeper
+		expected string
+	}{
+		{name: "default", gk: &Gogatekeeper{}, expected: DefaultGatekeeperImage},
+		{name: "operator default", options: InjectorOptions{Image: "mirror.example.com/gatekeeper:1.3.4"}, expected: "mirror.example.com/gatekeeper:1.3.4"},
+		{name: "spec image", options: InjectorOptions{Image: "mirror.example.com/gatekeeper:1.3.4"}, gk: custom, expected: custom.Spec.Image},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			injector := &gatekeeperInjector{Options: test.options}
+			if image := injector.image(test.gk); image != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, image)
+			}
+		})
+	}
+}
+
+func TestCredentialEnvEncryptionKey(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	withKey := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "with-key", Namespace: "apps"},
+		Data:       map[string][]byte{"PROXY_CLIENT_SECRET": []byte("secret"), "PROXY_ENCRYPTION_KEY": []byte("VtMkufTWXWA5x83C")},
+	}
+	withoutKey := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "without-key", Namespace: "apps"},
+		Data:       map[string][]byte{"PROXY_CLIENT_SECRET": []byte("secret")},
+	}
+	envWithKey := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "env-with-key", Namespace: "apps"},
+		Data:       map[string]string{"PROXY_ENCRYPTION_KEY": "VtMkufTWXWA5x83C"},
+	}
+	injector := &gatekeeperInjector{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(withKey, withoutKey, envWithKey).Build()}
+
+	generated := &Gogatekeeper{Status: GogatekeeperStatus{EncryptionKeySecret: "gk-encryption-key"}}
+	configured := &Gogatekeeper{
+		Spec:   GogatekeeperSpec{DefaultConfig: "encryption-key: VtMkufTWXWA5x83C\n"},
+		Status: GogatekeeperStatus{EncryptionKeySecret: "gk-encryption-key"},
+	}
+
+	tests := []struct {
+		name        string
+		gk          *Gogatekeeper
+		annotations map[string]string
+		generated   bool
+	}{
+		{name: "generated key", gk: generated, generated: true},
+		{name: "key in defaultconfig", gk: configured},
+		{name: "key annotation", gk: generated, annotations: map[string]string{"gatekeeper.gogatekeeper/encryption-key": "VtMkufTWXWA5x83C"}},
+		{name: "key in existingSecretEnv", gk: generated, annotations: map[string]string{"gatekeeper.gogatekeeper/existingSecretEnv": "with-key"}},
+		{name: "no key in existingSecretEnv", gk: generated, annotations: map[string]string{"gatekeeper.gogatekeeper/existingSecretEnv": "without-key"}, generated: true},
+		{name: "missing existingSecretEnv", gk: generated, annotations: map[string]string{"gatekeeper.gogatekeeper/existingSecretEnv": "missing"}, generated: true},
+		{name: "key in existingEnv", gk: generated, annotations: map[string]string{"gatekeeper.gogatekeeper/existingEnv": "env-with-key"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ownKey, err := injector.bringsEncryptionKey(context.Background(), "apps", test.annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := credentialEnv(test.gk, ownKey)
+			if generated := len(env) == 1 && env[0].Name == "PROXY_ENCRYPTION_KEY"; generated != test.generated || (!test.generated && len(env) != 0) {
+				t.Errorf("expected the generated key to be injected: %v, got %+v", test.generated, env)
+			}
+		})
+	}
+}
+
+// admissionRequest returns the admission request for creating or updating obj in the apps namespace
+func admissionRequest(t *testing.T, operation admissionv1.Operation, obj runtime.Object) admission.Request {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: operation,
+		Namespace: "apps",
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}
+
+// injectedContainer returns the gatekeeper container added by the patches of an admission response
+func injectedContainer(t *testing.T, patches []jsonpatch.JsonPatchOperation) corev1.Container {
+	t.Helper()
+	for _, patch := range patches {
+		if patch.Path != "/spec/containers/-" {
+			continue
+		}
+		container, ok := patch.Value.(corev1.Container)
+		if !ok {
+			t.Fatalf("expected a container to be added, got %v", patch.Value)
+		}
+		return container
+	}
+	t.Fatalf("expected a container to be added, got %v", patches)
+	return corev1.Container{}
+}
+
+func TestInjectorHandle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gk := &Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps"},
+		Spec: GogatekeeperSpec{
+			OIDCURL:         "https://idp.example.com",
+			ClientSecretRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "app-oidc"}, Key: "client-secret"},
+		},
+		Status: GogatekeeperStatus{ConfigMapName: "gk", EncryptionKeySecret: "gk-encryption-key"},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps"},
+		Data:       map[string]string{ConfigFileKey: "discovery-url: https://idp.example.com\nlisten: :3000\nlisten-admin: :4000\n"},
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+	injector := &gatekeeperInjector{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(gk, configMap, namespace).Build(),
+		Options: InjectorOptions{Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")},
+		}},
+	}
+	if err := injector.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	configArgs := []string{"--config", "/etc/gatekeeperConfig/gatekeeper.yaml"}
+	defaultPorts := []corev1.ContainerPort{{Name: "gatekeeper", ContainerPort: 3000}, {Name: "gatekeeper-admin", ContainerPort: 4000}}
+	defaultResources := corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")}}
+	appPorts := []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}}
+
+	tests := []struct {
+		name        string
+		operation   admissionv1.Operation
+		annotations map[string]string
+		ports       []corev1.ContainerPort
+		denied      bool
+		injected    bool
+		args        []string
+		resources   corev1.ResourceRequirements
+		gkPorts     []corev1.ContainerPort
+		probePort   int
+	}{
+		{
+			name:        "resource annotations",
+			annotations: map[string]string{"cpu-request": "100m", "memory-limit": "128Mi"},
+			injected:    true,
+			args:        configArgs,
+			resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+			},
+			gkPorts:   defaultPorts,
+			probePort: 4000,
+		},
+		{
+			name:        "upstream annotations",
+			annotations: map[string]string{"upstream-port": "http"},
+			ports:       appPorts,
+			injected:    true,
+			args:        append(configArgs, "--upstream-url", "http://127.0.0.1:8080"),
+			resources:   defaultResources,
+			gkPorts:     defaultPorts,
+			probePort:   4000,
+		},
+		{
+			name:        "listen annotations",
+			annotations: map[string]string{"listen": ":8000", "listen-admin": ":8000"},
+			injected:    true,
+			args:        append(configArgs, "--listen", ":8000", "--listen-admin", ":8000"),
+			resources:   defaultResources,
+			gkPorts:     []corev1.ContainerPort{{Name: "gatekeeper", ContainerPort: 8000}},
+			probePort:   8000,
+		},
+		{
+			name:        "cli annotations",
+			annotations: map[string]string{"enable-logging": "true", "redirection-url": "https://app.example.com"},
+			injected:    true,
+			args:        append(configArgs, "--enable-logging", "true", "--redirection-url", "https://app.example.com"),
+			resources:   defaultResources,
+			gkPorts:     defaultPorts,
+			probePort:   4000,
+		},
+		{
+			name:        "upstream url with upstream annotations",
+			annotations: map[string]string{"upstream-port": "http", "upstream-url": "http://127.0.0.1:9090"},
+			ports:       appPorts,
+			denied:      true,
+		},
+		{
+			name:        "invalid resource annotation",
+			annotations: map[string]string{"cpu-request": "lots"},
+			denied:      true,
+		},
+		{
+			name:        "update",
+			operation:   admissionv1.Update,
+			annotations: map[string]string{"cpu-request": "100m"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{"gatekeeper.gogatekeeper": "gk"}
+			for key, value := range test.annotations {
+				annotations["gatekeeper.gogatekeeper/"+key] = value
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps", Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app", Ports: test.ports}}},
+			}
+			operation := test.operation
+			if operation == "" {
+				operation = admissionv1.Create
+			}
+
+			response := injector.Handle(context.Background(), admissionRequest(t, operation, pod))
+			if response.Allowed == test.denied {
+				t.Fatalf("expected allowed %v, got %+v", !test.denied, response.Result)
+			}
+			if !test.injected {
+				if len(response.Patches) != 0 {
+					t.Errorf("expected no patches, got %v", response.Patches)
+				}
+				return
+			}
+
+			container := injectedContainer(t, response.Patches)
+			if !reflect.DeepEqual(container.Args, test.args) {
+				t.Errorf("expected args %v, got %v", test.args, container.Args)
+			}
+			if !equality.Semantic.DeepEqual(container.Resources, test.resources) {
+				t.Errorf("expected resources %v, got %v", test.resources, container.Resources)
+			}
+			if !reflect.DeepEqual(container.Ports, test.gkPorts) {
+				t.Errorf("expected ports %v, got %v", test.gkPorts, container.Ports)
+			}
+			for _, probe := range []*corev1.Probe{container.LivenessProbe, container.ReadinessProbe} {
+				if probe == nil || probe.HTTPGet == nil || probe.HTTPGet.Path != "/oauth/health" || probe.HTTPGet.Port != intstr.FromInt(test.probePort) {
+					t.Errorf("expected probes of /oauth/health on port %d, got %v", test.probePort, probe)
+				}
+			}
+			env := []string{}
+			for _, e := range container.Env {
+				env = append(env, e.Name)
+			}
+			if expected := []string{"PROXY_CLIENT_SECRET", "PROXY_ENCRYPTION_KEY"}; !reflect.DeepEqual(env, expected) {
+				t.Errorf("expected env %v, got %v", expected, env)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	yamlv3 "gopkg.in/yaml.v3"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+	"github.com/theEndBeta/gogatekeeper-operator/pkg/yamlmerge"
+)
+
+// gatekeeperConfigKey is the ConfigMap key holding the generated gatekeeper configuration
+const gatekeeperConfigKey = gatekeeperv1alpha1.ConfigFileKey
+
+// invalidConfigError is returned when the user supplied default configuration can not be used
+type invalidConfigError struct {
+	err error
+}
+
+func (e *invalidConfigError) Error() string {
+	return "invalid defaultconfig: " + e.err.Error()
+}
+
+// newGatekeeperConfigMap generates the gatekeeper configuration for a Gogatekeeper.
+// The operator managed configuration is deep-merged into the user's default configuration, and the
+// default configuration keys it overrode are returned along with the ConfigMap.
+func (r *GogatekeeperReconciler) newGatekeeperConfigMap(gk *gatekeeperv1alpha1.Gogatekeeper) (*corev1.ConfigMap, []string, error) {
+
+	log := ctrl.Log.WithName("configGenerator")
+
+	// Encode required configuration as yaml Node
+	extraConfNode, err := operatorConfig(gk)
+
+	if err != nil {
+		log.Error(err, "Failed to encode operator managed config")
+		return nil, nil, err
+	}
+
+	// Unmarshal user specified default configuration
+	// An unusable default configuration is an error rather than being dropped, so that the last good
+	// configuration is kept instead of being replaced by one missing the user's settings
+	defaultConfigNode := &yamlv3.Node{}
+	err = yamlv3.Unmarshal([]byte(gk.Spec.DefaultConfig), defaultConfigNode)
+	if err != nil {
+		return nil, nil, &invalidConfigError{err: err}
+	}
+	if _, err := yamlmerge.Mapping(defaultConfigNode); err != nil {
+		return nil, nil, &invalidConfigError{err: err}
+	}
+
+	// Merge the required config into the user's config, prioritizing the required CRD fields
+	merger := &yamlmerge.Merger{
+		Strategies: mergeStrategies(gk),
+		ListKeys:   map[string][]string{"resources": {"uri", "methods"}},
+	}
+	overridden, err := merger.Merge(defaultConfigNode, extraConfNode)
+	if err != nil {
+		log.Error(err, "Failed to merge default config")
+		return nil, nil, err
+	}
+
+	mergedConfigBytes, err := yamlv3.Marshal(defaultConfigNode)
+
+	if err != nil {
+		log.Error(err, "Failed to marshal merged config")
+		return nil, nil, err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      gk.Name,
+			Namespace: gk.Namespace,
+		},
+		Data: map[string]string{
+			gatekeeperConfigKey: string(mergedConfigBytes),
+		},
+	}
+
+	if err := ctrl.SetControllerReference(gk, configMap, r.Scheme); err != nil {
+		return nil, nil, err
+	}
+	return configMap, overridden, nil
+}
+
+// operatorConfig encodes the configuration set through the typed spec fields, which takes
+// precedence over the default configuration
+func operatorConfig(gk *gatekeeperv1alpha1.Gogatekeeper) (*yamlv3.Node, error) {
+	spec := gk.Spec
+	config := &configBuilder{node: &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}}
+
+	config.set("discovery-url", spec.OIDCURL)
+	config.setString("client-id", spec.ClientID)
+	config.setString("upstream-url", spec.UpstreamURL)
+	config.setString("listen", spec.Listen)
+	config.setString("listen-admin", spec.ListenAdmin)
+	if len(spec.Scopes) > 0 {
+		config.set("scopes", spec.Scopes)
+	}
+	config.setBool("enable-refresh-tokens", spec.EnableRefreshTokens)
+	config.setBool("enable-default-deny", spec.EnableDefaultDeny)
+	config.setBool("skip-upstream-tls-verify", spec.SkipUpstreamTLSVerify)
+	config.setBool("skip-openid-provider-tls-verify", spec.SkipOpenIDProviderTLSVerify)
+
+	if upstream := spec.UpstreamTLS; upstream != nil && upstream.CASecretRef != nil {
+		config.set("upstream-ca", path.Join(gatekeeperv1alpha1.UpstreamCAMountPath, "ca.crt"))
+	}
+	if spec.TLS != nil {
+		config.set("tls-cert", path.Join(gatekeeperv1alpha1.TLSMountPath, corev1.TLSCertKey))
+		config.set("tls-private-key", path.Join(gatekeeperv1alpha1.TLSMountPath, corev1.TLSPrivateKeyKey))
+	}
+
+	if cookie := spec.Cookie; cookie != nil {
+		config.setString("cookie-domain", cookie.Domain)
+		config.setString("cookie-path", cookie.Path)
+		config.setString("cookie-access-name", cookie.AccessName)
+		config.setString("cookie-refresh-name", cookie.RefreshName)
+		config.setBool("secure-cookie", cookie.Secure)
+		config.setBool("http-only-cookie", cookie.HTTPOnly)
+		config.setString("same-site-cookie", cookie.SameSite)
+	}
+
+	if len(spec.Resources) > 0 {
+		resources := []gatekeeperResource{}
+		for _, resource := range spec.Resources {
+			methods := []string{}
+			for _, method := range resource.Methods {
+				methods = append(methods, string(method))
+			}
+			resources = append(resources, gatekeeperResource{
+				URI:            resource.URI,
+				Methods:        methods,
+				Roles:          resource.Roles,
+				Groups:         resource.Groups,
+				WhiteListed:    resource.WhiteListed,
+				RequireAnyRole: resource.RequireAnyRole,
+			})
+		}
+		config.set("resources", resources)
+	}
+
+	return config.node, config.err
+}
+
+// gatekeeperResource is the configuration file representation of a Resource
+type gatekeeperResource struct {
+	URI            string   `yaml:"uri"`
+	Methods        []string `yaml:"methods,omitempty"`
+	Roles          []string `yaml:"roles,omitempty"`
+	Groups         []string `yaml:"groups,omitempty"`
+	WhiteListed    bool     `yaml:"white-listed,omitempty"`
+	RequireAnyRole bool     `yaml:"require-any-role,omitempty"`
+}
+
+// configBuilder builds a yaml mapping of gatekeeper options, keeping the order they are set in
+type configBuilder struct {
+	node *yamlv3.Node
+	err  error
+}
+
+func (b *configBuilder) set(option string, value interface{}) {
+	if b.err != nil {
+		return
+	}
+
+	valueNode := &yamlv3.Node{}
+	if err := valueNode.Encode(value); err != nil {
+		b.err = err
+		return
+	}
+
+	keyNode := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: option}
+	b.node.Content = append(b.node.Content, keyNode, valueNode)
+}
+
+// setString sets the option if the value is not empty
+func (b *configBuilder) setString(option, value string) {
+	if value != "" {
+		b.set(option, value)
+	}
+}
+
+// setBool sets the option if the value is not nil
+func (b *configBuilder) setBool(option string, value *bool) {
+	if value != nil {
+		b.set(option, *value)
+	}
+}
+
+// mergeStrategies converts the spec's merge strategies for use by the yaml merger.
+// List options set through typed fields replace the default configuration's unless configured otherwise,
+// as typed fields take precedence over it.
+func mergeStrategies(gk *gatekeeperv1alpha1.Gogatekeeper) map[string]yamlmerge.Strategy {
+	strategies := map[string]yamlmerge.Strategy{}
+	if len(gk.Spec.Scopes) > 0 {
+		strategies["scopes"] = yamlmerge.Replace
+	}
+	for option, strategy := range gk.Spec.MergeStrategies {
+		strategies[option] = yamlmerge.Strategy(strategy)
+	}
+	return strategies
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+)
+
+func TestNewGatekeeperConfigMap(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := gatekeeperv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	r := &GogatekeeperReconciler{Scheme: scheme}
+
+	tests := []struct {
+		name       string
+		spec       gatekeeperv1alpha1.GogatekeeperSpec
+		expected   map[string]interface{}
+		overridden []string
+		invalid    bool
+	}{
+		{
+			name: "typed fields",
+			spec: gatekeeperv1alpha1.GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				ClientID:      "app",
+				Listen:        ":8443",
+				DefaultConfig: "enable-logging: true\n",
+				TLS:           &gatekeeperv1alpha1.TLSSpec{SecretName: "app-tls"},
+			},
+			expected: map[string]interface{}{
+				"enable-logging":  true,
+				"discovery-url":   "https://idp.example.com",
+				"client-id":       "app",
+				"listen":          ":8443",
+				"tls-cert":        "/etc/gatekeeper/tls/tls.crt",
+				"tls-private-key": "/etc/gatekeeper/tls/tls.key",
+			},
+			overridden: []string{},
+		},
+		{
+			name: "typed fields take precedence",
+			spec: gatekeeperv1alpha1.GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				Listen:        ":8080",
+				DefaultConfig: "discovery-url: https://old.example.com\nlisten: :3000\n",
+			},
+			expected: map[string]interface{}{
+				"discovery-url": "https://idp.example.com",
+				"listen":        ":8080",
+			},
+			overridden: []string{"discovery-url", "listen"},
+		},
+		{
+			name: "typed scopes replace the default configuration's",
+			spec: gatekeeperv1alpha1.GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				Scopes:        []string{"profile"},
+				DefaultConfig: "scopes: [email]\n",
+			},
+			expected: map[string]interface{}{
+				"discovery-url": "https://idp.example.com",
+				"scopes":        []interface{}{"profile"},
+			},
+			overridden: []string{"scopes"},
+		},
+		{
+			name: "typed scopes merged on request",
+			spec: gatekeeperv1alpha1.GogatekeeperSpec{
+				OIDCURL:         "https://idp.example.com",
+				Scopes:          []string{"profile"},
+				DefaultConfig:   "scopes: [email]\n",
+				MergeStrategies: map[string]gatekeeperv1alpha1.MergeStrategy{"scopes": gatekeeperv1alpha1.MergeStrategyMerge},
+			},
+			expected: map[string]interface{}{
+				"discovery-url": "https://idp.example.com",
+				"scopes":        []interface{}{"email", "profile"},
+			},
+			overridden: []string{},
+		},
+		{
+			name: "resources merged by uri and methods",
+			spec: gatekeeperv1alpha1.GogatekeeperSpec{
+				OIDCURL: "https://idp.example.com",
+				Resources: []gatekeeperv1alpha1.Resource{
+					{URI: "/admin*", Methods: []gatekeeperv1alpha1.HTTPMethod{"POST"}, Roles: []string{"admin"}},
+				},
+				DefaultConfig: "resources:\n- uri: /admin*\n  methods: [GET]\n  roles: [reader]\n- uri: /admin*\n  methods: [POST]\n  roles: [writer]\n",
+			},
+			expected: map[string]interface{}{
+				"discovery-url": "https://idp.example.com",
+				"resources": []interface{}{
+					map[string]interface{}{"uri": "/admin*", "methods": []interface{}{"GET"}, "roles": []interface{}{"reader"}},
+					map[string]interface{}{"uri": "/admin*", "methods": []interface{}{"POST"}, "roles": []interface{}{"admin"}},
+				},
+			},
+			overridden: []string{"resources[uri=/admin*,methods=[POST]]"},
+		},
+		{
+			name: "aliases of overridden options",
+			spec: gatekeeperv1alpha1.GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				Listen:        ":8080",
+				DefaultConfig: "listen: &l :3000\nlisten-admin: *l\n",
+			},
+			expected: map[string]interface{}{
+				"discovery-url": "https://idp.example.com",
+				"listen":        ":8080",
+				"listen-admin":  ":3000",
+			},
+			overridden: []string{"listen"},
+		},
+		{
+			name: "invalid default config",
+			spec: gatekeeperv1alpha1.GogatekeeperSpec{
+				OIDCURL:       "https://idp.example.com",
+				DefaultConfig: "- listen\n",
+			},
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gk := &gatekeeperv1alpha1.Gogatekeeper{
+				ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps"},
+				Spec:       test.spec,
+			}
+			configMap, overridden, err := r.newGatekeeperConfigMap(gk)
+			if test.invalid {
+				if _, ok := err.(*invalidConfigError); !ok {
+					t.Errorf("expected an invalid config error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := map[string]interface{}{}
+			if err := yamlv3.Unmarshal([]byte(configMap.Data[gatekeeperConfigKey]), &config); err != nil {
+				t.Fatalf("generated configuration does not parse: %v", err)
+			}
+			if !reflect.DeepEqual(config, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, config)
+			}
+			if !reflect.DeepEqual(overridden, test.overridden) {
+				t.Errorf("expected overridden %v, got %v", test.overridden, overridden)
+			}
+			if !metav1.IsControlledBy(configMap, gk) {
+				t.Error("expected the ConfigMap to be controlled by the Gogatekeeper")
+			}
+		})
+	}
+}
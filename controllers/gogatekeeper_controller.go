@@ -18,17 +18,28 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
-
-	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
 )
@@ -36,15 +47,28 @@ import (
 // GogatekeeperReconciler reconciles a Gogatekeeper object
 type GogatekeeperReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// HTTPClient is used to reach OIDC providers, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// ProviderCheckInterval is how often OIDC providers are probed, DefaultProviderCheckInterval when zero
+	ProviderCheckInterval time.Duration
+	// Clock tells the time of provider checks, the real clock when nil
+	Clock clock.Clock
 }
 
 //+kubebuilder:rbac:groups=gatekeeper.theendbeta.me,resources=gogatekeepers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=gatekeeper.theendbeta.me,resources=gogatekeepers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=gatekeeper.theendbeta.me,resources=gogatekeepers/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -70,89 +94,213 @@ func (r *GogatekeeperReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
-	// Check for existing config
-	foundConf := &corev1.ConfigMap{}
-	err = r.Get(ctx, types.NamespacedName{Name: gatekeeper.Name, Namespace: gatekeeper.Namespace}, foundConf)
+	originalStatus := gatekeeper.Status.DeepCopy()
+	gatekeeper.Status.ObservedGeneration = gatekeeper.Generation
+
+	config, overridden, err := r.newGatekeeperConfigMap(gatekeeper)
 	if err != nil {
-		// Create new configMap
-		if errors.IsNotFound(err) {
-			config, err := r.newGatekeeperConfigMap(gatekeeper)
-			if err != nil {
-				log.Error(err, "Failed to generate gatekeeper config", "ConfigMap.Name", config.Name, "ConfigMap.Namespace", config.Namespace)
-				return ctrl.Result{}, err
-			}
+		log.Error(err, "Failed to generate gatekeeper config", "Gogatekeeper.Name", gatekeeper.Name, "Gogatekeeper.Namespace", gatekeeper.Namespace)
 
-			log.Info("Creating new gogatekeeper config map", "ConfigMap.Name", config.Name, "ConfigMap.Namespace", config.Namespace)
+		reason := "RenderFailed"
+		if _, ok := err.(*invalidConfigError); ok {
+			reason = "InvalidDefaultConfig"
+		}
+		r.Recorder.Event(gatekeeper, corev1.EventTypeWarning, reason, err.Error())
+		setCondition(gatekeeper, gatekeeperv1alpha1.ConditionConfigRendered, metav1.ConditionFalse, reason, err.Error())
 
-			err = r.Create(ctx, config)
+		// Leave the previous ConfigMap in place rather than replacing it with a broken configuration
+		if gatekeeper.Status.ConfigMapName != "" {
+			setCondition(gatekeeper, gatekeeperv1alpha1.ConditionConfigMapReady, metav1.ConditionFalse, "Stale", "ConfigMap holds the last successfully rendered configuration")
+		}
+		if statusErr := r.updateStatus(ctx, gatekeeper, originalStatus); statusErr != nil {
+			log.Error(statusErr, "Failed to update Gogatekeeper status")
+			return ctrl.Result{}, statusErr
+		}
 
-			if err != nil {
-				log.Error(err, "Failed to create new gogatekeeper config map", "ConfigMap.Name", config.Name, "ConfigMap.Namespace", config.Namespace)
-				return ctrl.Result{}, err
-			}
-			return ctrl.Result{Requeue: true}, nil
+		// Retrying will not help an invalid spec, we are triggered again once it is edited
+		if reason == "InvalidDefaultConfig" {
+			return ctrl.Result{}, nil
 		}
+		return ctrl.Result{}, err
+	}
+	renderedMessage := "gatekeeper.yaml generated from spec"
+	if len(overridden) > 0 {
+		log.Info("Default config keys overridden by operator managed configuration", "Keys", overridden)
+		renderedMessage += "; defaultconfig keys overridden: " + strings.Join(overridden, ", ")
+	}
+	setCondition(gatekeeper, gatekeeperv1alpha1.ConditionConfigRendered, metav1.ConditionTrue, "Rendered", renderedMessage)
 
-		log.Error(err, "Failed to get ConfigMap")
+	// Create or update the config map so that it always reflects the current spec,
+	// reverting any manual edits made to the owned ConfigMap
+	foundConf := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.Name,
+			Namespace: config.Namespace,
+		},
+	}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, foundConf, func() error {
+		if err := ensureControlled(gatekeeper, foundConf, "ConfigMap"); err != nil {
+			return err
+		}
+		foundConf.Data = config.Data
+		foundConf.BinaryData = nil
+		return ctrl.SetControllerReference(gatekeeper, foundConf, r.Scheme)
+	})
+	if err != nil {
+		log.Error(err, "Failed to reconcile gogatekeeper config map", "ConfigMap.Name", config.Name, "ConfigMap.Namespace", config.Namespace)
+		reason := "ReconcileFailed"
+		if _, ok := err.(*notOwnedError); ok {
+			reason = "NotOwned"
+			r.Recorder.Event(gatekeeper, corev1.EventTypeWarning, reason, err.Error())
+		}
+		setCondition(gatekeeper, gatekeeperv1alpha1.ConditionConfigMapReady, metav1.ConditionFalse, reason, err.Error())
+		if statusErr := r.updateStatus(ctx, gatekeeper, originalStatus); statusErr != nil {
+			log.Error(statusErr, "Failed to update Gogatekeeper status")
+		}
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
-}
+	if op != controllerutil.OperationResultNone {
+		log.Info("Reconciled gogatekeeper config map", "ConfigMap.Name", config.Name, "ConfigMap.Namespace", config.Namespace, "Operation", op)
+		r.Recorder.Eventf(gatekeeper, corev1.EventTypeNormal, "ConfigMapReconciled", "ConfigMap %s %s", config.Name, op)
+	}
+	gatekeeper.Status.ConfigMapName = config.Name
+	setCondition(gatekeeper, gatekeeperv1alpha1.ConditionConfigMapReady, metav1.ConditionTrue, "UpToDate", "ConfigMap matches the rendered configuration")
+
+	result := ctrl.Result{}
+	result.RequeueAfter, err = r.reconcileEncryptionKey(ctx, gatekeeper)
+	if err != nil {
+		log.Error(err, "Failed to reconcile gogatekeeper encryption key")
+		r.Recorder.Event(gatekeeper, corev1.EventTypeWarning, "EncryptionKeyFailed", err.Error())
+
+		// Keep the ConfigMap usable by the webhook, recording the failure rather than a key that was not saved
+		reason := "ReconcileFailed"
+		if _, ok := err.(*notOwnedError); ok {
+			reason = "NotOwned"
+		}
+		gatekeeper.Status.EncryptionKeySecret = originalStatus.EncryptionKeySecret
+		gatekeeper.Status.LastRotated = originalStatus.LastRotated
+		setCondition(gatekeeper, gatekeeperv1alpha1.ConditionEncryptionKeyReady, metav1.ConditionFalse, reason, err.Error())
+		if statusErr := r.updateStatus(ctx, gatekeeper, originalStatus); statusErr != nil {
+			log.Error(statusErr, "Failed to update Gogatekeeper status")
+		}
+		return ctrl.Result{}, err
+	}
+	if gatekeeper.GeneratesEncryptionKey() {
+		setCondition(gatekeeper, gatekeeperv1alpha1.ConditionEncryptionKeyReady, metav1.ConditionTrue, "Generated", "Encryption key generated in Secret "+gatekeeper.Status.EncryptionKeySecret)
+	} else {
+		setCondition(gatekeeper, gatekeeperv1alpha1.ConditionEncryptionKeyReady, metav1.ConditionTrue, "Configured", "Encryption key set by the spec")
+	}
+
+	if err := r.reconcileCertificates(ctx, gatekeeper); err != nil {
+		log.Error(err, "Failed to reconcile gatekeeper Certificates")
+		r.Recorder.Event(gatekeeper, corev1.EventTypeWarning, "CertificateFailed", err.Error())
 
-func (r *GogatekeeperReconciler) newGatekeeperConfigMap(gk *gatekeeperv1alpha1.Gogatekeeper) (*corev1.ConfigMap, error) {
+		reason := "ReconcileFailed"
+		if _, ok := err.(*notOwnedError); ok {
+			reason = "NotOwned"
+		}
+		setCondition(gatekeeper, gatekeeperv1alpha1.ConditionCertificatesReady, metav1.ConditionFalse, reason, err.Error())
+		if statusErr := r.updateStatus(ctx, gatekeeper, originalStatus); statusErr != nil {
+			log.Error(statusErr, "Failed to update Gogatekeeper status")
+		}
+		return ctrl.Result{}, err
+	}
+	if tls := gatekeeper.Spec.TLS; tls != nil && tls.CertManager != nil {
+		setCondition(gatekeeper, gatekeeperv1alpha1.ConditionCertificatesReady, metav1.ConditionTrue, "UpToDate", "Every workload using the Gogatekeeper has a Certificate")
+	} else {
+		meta.RemoveStatusCondition(&gatekeeper.Status.Conditions, gatekeeperv1alpha1.ConditionCertificatesReady)
+	}
 
-	log := ctrl.Log.WithName("configGenerator")
-	var mergedConfigNode *yamlv3.Node
+	// Restart injected workloads when the encryption key was rotated, which is retried until the
+	// new rotation time has been recorded in the status
+	if rotated := gatekeeper.Status.LastRotated; rotated != nil && originalStatus.LastRotated != nil && !rotated.Equal(originalStatus.LastRotated) {
+		r.Recorder.Event(gatekeeper, corev1.EventTypeNormal, "EncryptionKeyRotated", "Encryption key rotated")
+		if !gatekeeper.Spec.DisableRollout {
+			if err := r.rolloutWorkloads(ctx, gatekeeper, encryptionKeyRotatedAnnotation, rotated.UTC().Format(time.RFC3339)); err != nil {
+				log.Error(err, "Failed to restart workloads using Gogatekeeper")
+				return ctrl.Result{}, err
+			}
+		}
+	}
 
-	extraConfig := map[string]string{
-		"discovery-url": gk.Spec.OIDCURL,
+	// Restart injected workloads when the configuration changed, as gatekeeper only reads it on startup
+	hash := configHash(config.Data[gatekeeperConfigKey])
+	if originalStatus.ConfigHash != "" && originalStatus.ConfigHash != hash && !gatekeeper.Spec.DisableRollout {
+		if err := r.rolloutWorkloads(ctx, gatekeeper, configHashAnnotation, hash); err != nil {
+			log.Error(err, "Failed to restart workloads using Gogatekeeper")
+			return ctrl.Result{}, err
+		}
 	}
 
-	// Encode required configuration as yaml Node
-	extraConfNode := &yamlv3.Node{}
-	err := extraConfNode.Encode(extraConfig)
+	gatekeeper.Status.ConfigHash = hash
 
+	nextProviderCheck, err := r.checkProvider(ctx, gatekeeper)
 	if err != nil {
-		log.Error(err, "Failed to encode gatekeeper oidcurl")
-		return nil, err
+		log.Error(err, "Failed to restart workloads using Gogatekeeper")
+		return ctrl.Result{}, err
+	}
+	result.RequeueAfter = minRequeue(result.RequeueAfter, nextProviderCheck)
+
+	if err := r.updateStatus(ctx, gatekeeper, originalStatus); err != nil {
+		log.Error(err, "Failed to update Gogatekeeper status")
+		return ctrl.Result{}, err
 	}
 
-	// Unmarshal user specified default configuration
-	defaultConfig := gk.Spec.DefaultConfig
-	defaultConfigNode := &yamlv3.Node{}
-	err = yamlv3.Unmarshal([]byte(defaultConfig), defaultConfigNode)
+	return result, nil
+}
 
-	// Try to merge the user's config and the required config, prioritizing the required CRD fields
-	// If we can't unmarshal the user's config, we still have the required configuration to marshal
-	if err != nil {
-		log.Error(err, "Failed to unmarshal default config")
-		mergedConfigNode = extraConfNode
-	} else {
-		content := defaultConfigNode.Content[0]
-		content.Content = append(content.Content, extraConfNode.Content...)
-		mergedConfigNode = defaultConfigNode
+// updateStatus writes the status subresource if it differs from the original status
+func (r *GogatekeeperReconciler) updateStatus(ctx context.Context, gk *gatekeeperv1alpha1.Gogatekeeper, original *gatekeeperv1alpha1.GogatekeeperStatus) error {
+	if equality.Semantic.DeepEqual(original, &gk.Status) {
+		return nil
 	}
+	return r.Status().Update(ctx, gk)
+}
 
-	mergedConfigBytes, err := yamlv3.Marshal(mergedConfigNode)
+// notOwnedError is returned when an object the operator would manage for a Gogatekeeper already exists
+// without being controlled by it
+type notOwnedError struct {
+	kind string
+	name string
+}
 
-	if err != nil {
-		log.Error(err, "Failed to marshal merged config")
-		return nil, err
+func (e *notOwnedError) Error() string {
+	return fmt.Sprintf("%s %s already exists and is not controlled by this Gogatekeeper", e.kind, e.name)
+}
+
+// ensureControlled fails with a *notOwnedError when obj exists but is not controlled by the Gogatekeeper,
+// so that objects created by someone else are never taken over
+func ensureControlled(gk *gatekeeperv1alpha1.Gogatekeeper, obj client.Object, kind string) error {
+	if obj.GetResourceVersion() == "" || metav1.IsControlledBy(obj, gk) {
+		return nil
 	}
+	return &notOwnedError{kind: kind, name: obj.GetName()}
+}
 
-	configMap := &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      gk.Name,
-			Namespace: gk.Namespace,
-		},
-		Data: map[string]string{
-			"gatekeeper.yaml": string(mergedConfigBytes),
-		},
+// now returns the current time according to the reconciler's clock
+func (r *GogatekeeperReconciler) now() time.Time {
+	if r.Clock == nil {
+		return time.Now()
 	}
+	return r.Clock.Now()
+}
+
+// setCondition sets a status condition on the Gogatekeeper for its current generation
+func setCondition(gk *gatekeeperv1alpha1.Gogatekeeper, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&gk.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: gk.Generation,
+	})
+}
 
-	ctrl.SetControllerReference(gk, configMap, r.Scheme)
-	return configMap, nil
+// configHash returns the hex encoded sha256 hash of the rendered configuration
+func configHash(config string) string {
+	sum := sha256.Sum256([]byte(config))
+	return hex.EncodeToString(sum[:])
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -160,5 +308,9 @@ func (r *GogatekeeperReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&gatekeeperv1alpha1.Gogatekeeper{}).
 		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}}, handler.EnqueueRequestsFromMapFunc(injectedGogatekeeper)).
+		Watches(&source.Kind{Type: &appsv1.StatefulSet{}}, handler.EnqueueRequestsFromMapFunc(injectedGogatekeeper)).
+		Watches(&source.Kind{Type: &appsv1.DaemonSet{}}, handler.EnqueueRequestsFromMapFunc(injectedGogatekeeper)).
 		Complete(r)
 }
@@ -0,0 +1,211 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+)
+
+func TestReconcileForeignConfigMap(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatekeeperv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	gk := &gatekeeperv1alpha1.Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps", Generation: 1},
+		Spec:       gatekeeperv1alpha1.GogatekeeperSpec{OIDCURL: "https://idp.example.com"},
+	}
+	foreign := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps"},
+		Data:       map[string]string{"app.properties": "owned by someone else"},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	r := &GogatekeeperReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(gk, foreign).Build(),
+		Scheme:   scheme,
+		Recorder: recorder,
+	}
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "apps", Name: "gk"}
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatal("expected reconciling into a foreign ConfigMap to fail")
+	}
+	expectEvents(t, recorder, "NotOwned")
+
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, key, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(configMap.OwnerReferences) != 0 || configMap.Data["app.properties"] != "owned by someone else" || len(configMap.Data) != 1 {
+		t.Errorf("expected the foreign ConfigMap to be left untouched, got %+v", configMap)
+	}
+
+	updated := &gatekeeperv1alpha1.Gogatekeeper{}
+	if err := r.Get(ctx, key, updated); err != nil {
+		t.Fatal(err)
+	}
+	condition := meta.FindStatusCondition(updated.Status.Conditions, gatekeeperv1alpha1.ConditionConfigMapReady)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != "NotOwned" {
+		t.Errorf("expected ConfigMapReady to be False with reason NotOwned, got %+v", condition)
+	}
+	if updated.Status.ConfigMapName != "" {
+		t.Errorf("expected no ConfigMap to be recorded, got %q", updated.Status.ConfigMapName)
+	}
+}
+
+func TestReconcileForeignEncryptionKeySecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatekeeperv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	gk := &gatekeeperv1alpha1.Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps", Generation: 1},
+		Spec:       gatekeeperv1alpha1.GogatekeeperSpec{OIDCURL: "https://idp.example.com"},
+	}
+	foreign := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk-encryption-key", Namespace: "apps"},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	r := &GogatekeeperReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(gk, foreign).Build(),
+		Scheme:   scheme,
+		Recorder: recorder,
+	}
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "apps", Name: "gk"}
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatal("expected reconciling into a foreign encryption key Secret to fail")
+	}
+	expectEvents(t, recorder, "ConfigMapReconciled", "EncryptionKeyFailed")
+
+	updated := &gatekeeperv1alpha1.Gogatekeeper{}
+	if err := r.Get(ctx, key, updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status.ConfigMapName != "gk" {
+		t.Errorf("expected the generated ConfigMap to be recorded, got %q", updated.Status.ConfigMapName)
+	}
+	if condition := meta.FindStatusCondition(updated.Status.Conditions, gatekeeperv1alpha1.ConditionConfigMapReady); condition == nil || condition.Status != metav1.ConditionTrue {
+		t.Errorf("expected ConfigMapReady to be True, got %+v", condition)
+	}
+	condition := meta.FindStatusCondition(updated.Status.Conditions, gatekeeperv1alpha1.ConditionEncryptionKeyReady)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != "NotOwned" {
+		t.Errorf("expected EncryptionKeyReady to be False with reason NotOwned, got %+v", condition)
+	}
+	if updated.Status.EncryptionKeySecret != "" {
+		t.Errorf("expected no encryption key Secret to be recorded, got %q", updated.Status.EncryptionKeySecret)
+	}
+}
+
+func TestReconcileConfigMap(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatekeeperv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	provider, otherProvider := newTestProvider(t), newTestProvider(t)
+
+	gk := &gatekeeperv1alpha1.Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps", Generation: 1},
+		Spec: gatekeeperv1alpha1.GogatekeeperSpec{
+			OIDCURL:          provider.URL,
+			EncryptionKeyRef: &corev1.SecretKeySelector{Key: "key"},
+		},
+	}
+	r := &GogatekeeperReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(gk).Build(),
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(10),
+	}
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "apps", Name: "gk"}
+
+	reconcileConfig := func() *corev1.ConfigMap {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatal(err)
+		}
+		configMap := &corev1.ConfigMap{}
+		if err := r.Get(ctx, key, configMap); err != nil {
+			t.Fatal(err)
+		}
+		if !metav1.IsControlledBy(configMap, gk) {
+			t.Errorf("expected the ConfigMap to be controlled by the Gogatekeeper, got %v", configMap.OwnerReferences)
+		}
+		return configMap
+	}
+
+	configMap := reconcileConfig()
+	if config := configMap.Data[gatekeeperConfigKey]; !strings.Contains(config, "discovery-url: "+provider.URL) {
+		t.Errorf("expected the generated configuration to use %s, got %q", provider.URL, config)
+	}
+
+	// Changes to oidcurl and defaultconfig are applied to the existing ConfigMap
+	if err := r.Get(ctx, key, gk); err != nil {
+		t.Fatal(err)
+	}
+	gk.Spec.OIDCURL = otherProvider.URL
+	gk.Spec.DefaultConfig = "enable-logging: true\n"
+	gk.Generation++
+	if err := r.Update(ctx, gk); err != nil {
+		t.Fatal(err)
+	}
+	configMap = reconcileConfig()
+	updated := configMap.Data[gatekeeperConfigKey]
+	if !strings.Contains(updated, "discovery-url: "+otherProvider.URL) || !strings.Contains(updated, "enable-logging: true") {
+		t.Errorf("expected the configuration to follow the spec, got %q", updated)
+	}
+
+	// Manual edits to the ConfigMap are reverted
+	configMap.Data[gatekeeperConfigKey] = "discovery-url: https://evil.example.com\n"
+	configMap.BinaryData = map[string][]byte{"extra": []byte("data")}
+	if err := r.Update(ctx, configMap); err != nil {
+		t.Fatal(err)
+	}
+	configMap = reconcileConfig()
+	if configMap.Data[gatekeeperConfigKey] != updated || len(configMap.BinaryData) != 0 {
+		t.Errorf("expected manual edits to be reverted, got %v and %v", configMap.Data, configMap.BinaryData)
+	}
+}
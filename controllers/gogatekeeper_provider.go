@@ -0,0 +1,183 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+	"github.com/theEndBeta/gogatekeeper-operator/pkg/oidc"
+)
+
+// DefaultProviderCheckInterval is how often the OIDC provider is probed when no interval is configured
+const DefaultProviderCheckInterval = 5 * time.Minute
+
+// signingKeysAnnotation is set on the pod templates of injected workloads to a hash of the provider's
+// signing key IDs when rolloutOnSigningKeyRotation is set
+const signingKeysAnnotation = "gatekeeper.theendbeta.me/signing-keys-hash"
+
+// providerTimeout bounds a single probe of the OIDC provider
+const providerTimeout = 10 * time.Second
+
+// checkProvider fetches the discovery document and signing keys of the Gogatekeeper's OIDC provider and records
+// the result in the ProviderReachable condition. Failures to reach the provider are reported, but do not fail the
+// reconcile; only failing to restart workloads after a signing key rotation is returned.
+// The provider is only checked once per interval for a generation of the spec, as reconciles are also triggered
+// by changes to the workloads using the Gogatekeeper. It returns how long until the next check is due.
+func (r *GogatekeeperReconciler) checkProvider(ctx context.Context, gk *gatekeeperv1alpha1.Gogatekeeper) (time.Duration, error) {
+	log := log.FromContext(ctx)
+
+	interval, now := r.providerCheckInterval(), r.now()
+	condition := meta.FindStatusCondition(gk.Status.Conditions, gatekeeperv1alpha1.ConditionProviderReachable)
+	if last := gk.Status.LastProviderCheck; last != nil && condition != nil && condition.ObservedGeneration == gk.Generation {
+		if next := last.Add(interval).Sub(now); next > 0 {
+			return next, nil
+		}
+	}
+	gk.Status.LastProviderCheck = &metav1.Time{Time: now.UTC().Truncate(time.Second)}
+
+	probeCtx, cancel := context.WithTimeout(ctx, providerTimeout)
+	defer cancel()
+
+	client, err := r.providerClient(ctx, gk)
+	if err != nil {
+		log.Info("OIDC provider CA could not be loaded", "Error", err.Error())
+		r.Recorder.Event(gk, corev1.EventTypeWarning, "InvalidProviderCA", err.Error())
+		setCondition(gk, gatekeeperv1alpha1.ConditionProviderReachable, metav1.ConditionFalse, "InvalidProviderCA", err.Error())
+		return interval, nil
+	}
+	discovery, err := oidc.Discover(probeCtx, client, gk.Spec.OIDCURL)
+	if err != nil {
+		log.Info("OIDC provider check failed", "URL", oidc.DiscoveryURL(gk.Spec.OIDCURL), "Error", err.Error())
+		r.Recorder.Event(gk, corev1.EventTypeWarning, "ProviderUnreachable", err.Error())
+		setCondition(gk, gatekeeperv1alpha1.ConditionProviderReachable, metav1.ConditionFalse, "DiscoveryFailed", err.Error())
+		return interval, nil
+	}
+
+	keyIDs, err := oidc.KeyIDs(probeCtx, client, discovery.JWKSURI)
+	if err != nil {
+		log.Info("OIDC provider signing keys could not be fetched", "URL", discovery.JWKSURI, "Error", err.Error())
+		r.Recorder.Event(gk, corev1.EventTypeWarning, "ProviderKeysUnavailable", err.Error())
+		setCondition(gk, gatekeeperv1alpha1.ConditionProviderReachable, metav1.ConditionFalse, "KeysUnavailable", err.Error())
+		return interval, nil
+	}
+
+	if !meta.IsStatusConditionTrue(gk.Status.Conditions, gatekeeperv1alpha1.ConditionProviderReachable) {
+		r.Recorder.Eventf(gk, corev1.EventTypeNormal, "ProviderReachable", "Discovered issuer %s", discovery.Issuer)
+	}
+	gk.Status.Issuer = discovery.Issuer
+	setCondition(gk, gatekeeperv1alpha1.ConditionProviderReachable, metav1.ConditionTrue, "Discovered", "Discovered issuer "+discovery.Issuer)
+
+	previousKeyIDs := gk.Status.SigningKeyIDs
+	if len(previousKeyIDs) > 0 && !equality.Semantic.DeepEqual(previousKeyIDs, keyIDs) {
+		log.Info("OIDC provider signing keys changed", "Previous", previousKeyIDs, "Current", keyIDs)
+		r.Recorder.Eventf(gk, corev1.EventTypeNormal, "SigningKeysRotated", "Provider signing keys changed to %s", strings.Join(keyIDs, ", "))
+
+		if gk.Spec.RolloutOnSigningKeyRotation && !gk.Spec.DisableRollout {
+			// The status is only updated once the rollout succeeded, so that it is retried otherwise
+			if err := r.rolloutWorkloads(ctx, gk, signingKeysAnnotation, configHash(strings.Join(keyIDs, ","))); err != nil {
+				return 0, err
+			}
+		}
+	}
+	gk.Status.SigningKeyIDs = keyIDs
+	return interval, nil
+}
+
+// providerClient returns the HTTP client used to reach the Gogatekeeper's OIDC provider,
+// trusting its providerCA or skipping verification when requested by the spec
+func (r *GogatekeeperReconciler) providerClient(ctx context.Context, gk *gatekeeperv1alpha1.Gogatekeeper) (*http.Client, error) {
+	client := r.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	skipVerify := gk.Spec.SkipOpenIDProviderTLSVerify != nil && *gk.Spec.SkipOpenIDProviderTLSVerify
+	if !skipVerify && gk.Spec.ProviderCA == nil {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: skipVerify} //nolint:gosec // requested by the spec, like gatekeeper itself
+	if !skipVerify {
+		pool, err := r.providerCAPool(ctx, gk)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if t, ok := client.Transport.(*http.Transport); ok {
+		transport = t.Clone()
+	}
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: client.Timeout}, nil
+}
+
+// providerCAPool returns the system CAs together with the Gogatekeeper's providerCA bundle
+func (r *GogatekeeperReconciler) providerCAPool(ctx context.Context, gk *gatekeeperv1alpha1.Gogatekeeper) (*x509.CertPool, error) {
+	var bundle []byte
+	ca := gk.Spec.ProviderCA
+	switch {
+	case ca.ConfigMapKeyRef != nil:
+		configMap := &corev1.ConfigMap{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: gk.Namespace, Name: ca.ConfigMapKeyRef.Name}, configMap); err != nil {
+			return nil, fmt.Errorf("reading providerCA: %w", err)
+		}
+		if data, ok := configMap.Data[ca.ConfigMapKeyRef.Key]; ok {
+			bundle = []byte(data)
+		} else {
+			bundle = configMap.BinaryData[ca.ConfigMapKeyRef.Key]
+		}
+	case ca.SecretKeyRef != nil:
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: gk.Namespace, Name: ca.SecretKeyRef.Name}, secret); err != nil {
+			return nil, fmt.Errorf("reading providerCA: %w", err)
+		}
+		bundle = secret.Data[ca.SecretKeyRef.Key]
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("providerCA holds no PEM encoded certificates")
+	}
+	return pool, nil
+}
+
+// providerCheckInterval returns how long to wait before probing the OIDC provider again
+func (r *GogatekeeperReconciler) providerCheckInterval() time.Duration {
+	if r.ProviderCheckInterval > 0 {
+		return r.ProviderCheckInterval
+	}
+	return DefaultProviderCheckInterval
+}
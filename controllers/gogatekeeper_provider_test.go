@@ -0,0 +1,188 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+	"github.com/theEndBeta/gogatekeeper-operator/pkg/oidc"
+)
+
+// testProvider is a stand-in OIDC provider whose signing keys and availability can be changed
+type testProvider struct {
+	*httptest.Server
+	keyIDs   []string
+	down     bool
+	requests int
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	provider := &testProvider{keyIDs: []string{"key-1"}}
+	provider.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider.requests++
+		if provider.down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case oidc.DiscoveryPath:
+			fmt.Fprintf(w, `{"issuer": %q, "authorization_endpoint": "%[1]s/auth", "token_endpoint": "%[1]s/token", "jwks_uri": "%[1]s/keys"}`, provider.URL)
+		case "/keys":
+			keys := []string{}
+			for _, kid := range provider.keyIDs {
+				keys = append(keys, fmt.Sprintf(`{"kid": %q, "kty": "RSA"}`, kid))
+			}
+			fmt.Fprintf(w, `{"keys": [%s]}`, strings.Join(keys, ","))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(provider.Close)
+	return provider
+}
+
+// expectEvents checks the reasons of the events recorded since the last call
+func expectEvents(t *testing.T, recorder *record.FakeRecorder, reasons ...string) {
+	t.Helper()
+	recorded := []string{}
+	for len(recorder.Events) > 0 {
+		fields := strings.Fields(<-recorder.Events)
+		recorded = append(recorded, fields[1])
+	}
+	if strings.Join(recorded, ",") != strings.Join(reasons, ",") {
+		t.Errorf("expected events %v, got %v", reasons, recorded)
+	}
+}
+
+func expectProviderCondition(t *testing.T, gk *gatekeeperv1alpha1.Gogatekeeper, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	condition := meta.FindStatusCondition(gk.Status.Conditions, gatekeeperv1alpha1.ConditionProviderReachable)
+	if condition == nil || condition.Status != status || condition.Reason != reason {
+		t.Errorf("expected ProviderReachable %s/%s, got %v", status, reason, condition)
+	}
+}
+
+func TestCheckProvider(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatekeeperv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := newTestProvider(t)
+	gk := &gatekeeperv1alpha1.Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps", Generation: 1},
+		Spec: gatekeeperv1alpha1.GogatekeeperSpec{
+			OIDCURL:                     provider.URL,
+			RolloutOnSigningKeyRotation: true,
+		},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{gatekeeperAnnotation: "gk"}},
+		}},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	fakeClock := clock.NewFakeClock(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+	r := &GogatekeeperReconciler{
+		Client:                fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment).Build(),
+		Scheme:                scheme,
+		Recorder:              recorder,
+		ProviderCheckInterval: 5 * time.Minute,
+		Clock:                 fakeClock,
+	}
+	ctx := context.Background()
+
+	check := func(expectedNext time.Duration) {
+		t.Helper()
+		next, err := r.checkProvider(ctx, gk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != expectedNext {
+			t.Errorf("expected the next check in %s, got %s", expectedNext, next)
+		}
+	}
+
+	// The first check discovers the provider
+	check(5 * time.Minute)
+	expectProviderCondition(t, gk, metav1.ConditionTrue, "Discovered")
+	expectEvents(t, recorder, "ProviderReachable")
+	if gk.Status.Issuer != provider.URL || !reflect.DeepEqual(gk.Status.SigningKeyIDs, []string{"key-1"}) {
+		t.Errorf("unexpected provider status %s %v", gk.Status.Issuer, gk.Status.SigningKeyIDs)
+	}
+
+	// Reconciles within the interval do not reach the provider
+	requests := provider.requests
+	fakeClock.Step(time.Minute)
+	check(4 * time.Minute)
+	if provider.requests != requests {
+		t.Errorf("expected the provider not to be checked within the interval, got %d requests", provider.requests-requests)
+	}
+
+	// A rotation of the signing keys restarts the injected workloads once the interval has passed
+	provider.keyIDs = []string{"key-2", "key-3"}
+	fakeClock.Step(4 * time.Minute)
+	check(5 * time.Minute)
+	expectEvents(t, recorder, "SigningKeysRotated")
+	if !reflect.DeepEqual(gk.Status.SigningKeyIDs, []string{"key-2", "key-3"}) {
+		t.Errorf("expected the rotated key IDs, got %v", gk.Status.SigningKeyIDs)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "app"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if deployment.Spec.Template.Annotations[signingKeysAnnotation] != configHash("key-2,key-3") {
+		t.Errorf("expected the deployment to be restarted, got annotations %v", deployment.Spec.Template.Annotations)
+	}
+
+	// An unreachable provider is reported, and checked again straight away once the spec changes
+	provider.down = true
+	gk.Generation = 2
+	check(5 * time.Minute)
+	expectProviderCondition(t, gk, metav1.ConditionFalse, "DiscoveryFailed")
+	expectEvents(t, recorder, "ProviderUnreachable")
+
+	// Recovering is reported once the next check is due
+	provider.down = false
+	fakeClock.Step(5 * time.Minute)
+	check(5 * time.Minute)
+	expectProviderCondition(t, gk, metav1.ConditionTrue, "Discovered")
+	expectEvents(t, recorder, "ProviderReachable")
+}
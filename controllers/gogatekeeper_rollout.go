@@ -0,0 +1,135 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+)
+
+// gatekeeperAnnotation is the pod annotation naming the Gogatekeeper to inject (see gatekeeperInjector)
+const gatekeeperAnnotation = "gatekeeper.gogatekeeper"
+
+// configHashAnnotation is set on the pod templates of injected workloads to the hash of the
+// configuration they should be running, so that changing it triggers a rolling restart
+const configHashAnnotation = "gatekeeper.theendbeta.me/config-hash"
+
+// workload is a Deployment, StatefulSet or DaemonSet along with its pod template
+type workload struct {
+	kind     string
+	obj      client.Object
+	template *corev1.PodTemplateSpec
+}
+
+// injectedWorkloads lists the workloads in the Gogatekeeper's namespace whose pod templates
+// request injection of that Gogatekeeper
+func (r *GogatekeeperReconciler) injectedWorkloads(ctx context.Context, gk *gatekeeperv1alpha1.Gogatekeeper) ([]workload, error) {
+	workloads := []workload{}
+
+	deployments := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deployments, client.InNamespace(gk.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		workloads = append(workloads, workload{kind: "Deployment", obj: &deployments.Items[i], template: &deployments.Items[i].Spec.Template})
+	}
+
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, statefulSets, client.InNamespace(gk.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		workloads = append(workloads, workload{kind: "StatefulSet", obj: &statefulSets.Items[i], template: &statefulSets.Items[i].Spec.Template})
+	}
+
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := r.List(ctx, daemonSets, client.InNamespace(gk.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		workloads = append(workloads, workload{kind: "DaemonSet", obj: &daemonSets.Items[i], template: &daemonSets.Items[i].Spec.Template})
+	}
+
+	injected := []workload{}
+	for _, w := range workloads {
+		if w.template.Annotations[gatekeeperAnnotation] == gk.Name {
+			injected = append(injected, w)
+		}
+	}
+	return injected, nil
+}
+
+// injectedGogatekeeper maps a Deployment, StatefulSet or DaemonSet to the Gogatekeeper its pod template injects,
+// so that the Gogatekeeper is reconciled when workloads using it are created or changed
+func injectedGogatekeeper(obj client.Object) []reconcile.Request {
+	var template *corev1.PodTemplateSpec
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		template = &o.Spec.Template
+	case *appsv1.StatefulSet:
+		template = &o.Spec.Template
+	case *appsv1.DaemonSet:
+		template = &o.Spec.Template
+	default:
+		return nil
+	}
+
+	name, ok := template.Annotations[gatekeeperAnnotation]
+	if !ok || name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}}}
+}
+
+// rolloutWorkloads sets the pod template annotation to value on every workload injecting the
+// Gogatekeeper, which makes their controllers perform a rolling restart. Workloads already
+// carrying the value are left untouched, so it is safe to call repeatedly.
+func (r *GogatekeeperReconciler) rolloutWorkloads(ctx context.Context, gk *gatekeeperv1alpha1.Gogatekeeper, annotation, value string) error {
+	log := log.FromContext(ctx)
+
+	workloads, err := r.injectedWorkloads(ctx, gk)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range workloads {
+		if w.template.Annotations[annotation] == value {
+			continue
+		}
+
+		patch := client.MergeFrom(w.obj.DeepCopyObject().(client.Object))
+		if w.template.Annotations == nil {
+			w.template.Annotations = map[string]string{}
+		}
+		w.template.Annotations[annotation] = value
+
+		log.Info("Restarting workload to pick up gatekeeper changes", "Kind", w.kind, "Name", w.obj.GetName(), "Annotation", annotation)
+		if err := r.Patch(ctx, w.obj, patch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
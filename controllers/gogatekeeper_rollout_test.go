@@ -0,0 +1,211 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+)
+
+// patchCounter counts the Patch calls made through a client
+type patchCounter struct {
+	client.Client
+	patches int
+}
+
+func (c *patchCounter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.patches++
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// newRolloutWorkloads returns a Deployment, StatefulSet and DaemonSet injecting the Gogatekeeper gk,
+// a DaemonSet injecting another one and a Deployment without injection
+func newRolloutWorkloads() []client.Object {
+	template := func(gogatekeeper string) corev1.PodTemplateSpec {
+		if gogatekeeper == "" {
+			return corev1.PodTemplateSpec{}
+		}
+		return corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{gatekeeperAnnotation: gogatekeeper}}}
+	}
+	return []client.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps"}, Spec: appsv1.DeploymentSpec{Template: template("gk")}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "apps"}, Spec: appsv1.StatefulSetSpec{Template: template("gk")}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "apps"}, Spec: appsv1.DaemonSetSpec{Template: template("gk")}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "logs", Namespace: "apps"}, Spec: appsv1.DaemonSetSpec{Template: template("other")}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "apps"}, Spec: appsv1.DeploymentSpec{Template: template("")}},
+	}
+}
+
+// templateAnnotations returns the value of a pod template annotation on each of the rollout test workloads
+func templateAnnotations(t *testing.T, c client.Client, annotation string) map[string]string {
+	t.Helper()
+	values := map[string]string{}
+	for _, obj := range newRolloutWorkloads() {
+		if err := c.Get(context.Background(), types.NamespacedName{Namespace: "apps", Name: obj.GetName()}, obj); err != nil {
+			t.Fatal(err)
+		}
+		var template corev1.PodTemplateSpec
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			template = o.Spec.Template
+		case *appsv1.StatefulSet:
+			template = o.Spec.Template
+		case *appsv1.DaemonSet:
+			template = o.Spec.Template
+		}
+		values[obj.GetName()] = template.Annotations[annotation]
+	}
+	return values
+}
+
+func TestInjectedGogatekeeper(t *testing.T) {
+	requests := []reconcile.Request{}
+	for _, obj := range append(newRolloutWorkloads(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "pod", Namespace: "apps", Annotations: map[string]string{gatekeeperAnnotation: "gk"},
+	}}) {
+		requests = append(requests, injectedGogatekeeper(obj)...)
+	}
+
+	gk := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "apps", Name: "gk"}}
+	other := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "apps", Name: "other"}}
+	if expected := []reconcile.Request{gk, gk, gk, other}; !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected requests %v, got %v", expected, requests)
+	}
+}
+
+func TestRolloutWorkloads(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &patchCounter{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(newRolloutWorkloads()...).Build()}
+	r := &GogatekeeperReconciler{Client: c, Scheme: scheme}
+	gk := &gatekeeperv1alpha1.Gogatekeeper{ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps"}}
+	ctx := context.Background()
+
+	workloads, err := r.injectedWorkloads(ctx, gk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, w := range workloads {
+		names = append(names, w.kind+"/"+w.obj.GetName())
+	}
+	if expected := []string{"Deployment/app", "StatefulSet/db", "DaemonSet/agent"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected injected workloads %v, got %v", expected, names)
+	}
+
+	if err := r.rolloutWorkloads(ctx, gk, configHashAnnotation, "hash-1"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"app": "hash-1", "db": "hash-1", "agent": "hash-1", "logs": "", "plain": ""}
+	if annotations := templateAnnotations(t, c, configHashAnnotation); !reflect.DeepEqual(annotations, expected) {
+		t.Errorf("expected config hashes %v, got %v", expected, annotations)
+	}
+	if c.patches != 3 {
+		t.Errorf("expected 3 patches, got %d", c.patches)
+	}
+
+	// Workloads already carrying the value are left alone
+	c.patches = 0
+	if err := r.rolloutWorkloads(ctx, gk, configHashAnnotation, "hash-1"); err != nil {
+		t.Fatal(err)
+	}
+	if c.patches != 0 {
+		t.Errorf("expected no patches for an unchanged value, got %d", c.patches)
+	}
+}
+
+func TestReconcileConfigHashRollout(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatekeeperv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	provider := newTestProvider(t)
+
+	tests := []struct {
+		name           string
+		previousHash   string
+		disableRollout bool
+		rolledOut      bool
+	}{
+		{name: "configuration changed", previousHash: "old", rolledOut: true},
+		{name: "first configuration", previousHash: ""},
+		{name: "configuration changed with rollout disabled", previousHash: "old", disableRollout: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gk := &gatekeeperv1alpha1.Gogatekeeper{
+				ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps", Generation: 1},
+				Spec: gatekeeperv1alpha1.GogatekeeperSpec{
+					OIDCURL:          provider.URL,
+					EncryptionKeyRef: &corev1.SecretKeySelector{Key: "key"},
+					DisableRollout:   test.disableRollout,
+				},
+				Status: gatekeeperv1alpha1.GogatekeeperStatus{ConfigHash: test.previousHash},
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(newRolloutWorkloads(), gk)...).Build()
+			r := &GogatekeeperReconciler{Client: c, Scheme: scheme, Recorder: record.NewFakeRecorder(10)}
+			ctx := context.Background()
+			key := types.NamespacedName{Namespace: "apps", Name: "gk"}
+
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatal(err)
+			}
+
+			configMap := &corev1.ConfigMap{}
+			if err := c.Get(ctx, key, configMap); err != nil {
+				t.Fatal(err)
+			}
+			hash := configHash(configMap.Data[gatekeeperConfigKey])
+			expected := map[string]string{"app": "", "db": "", "agent": "", "logs": "", "plain": ""}
+			if test.rolledOut {
+				expected = map[string]string{"app": hash, "db": hash, "agent": hash, "logs": "", "plain": ""}
+			}
+			if annotations := templateAnnotations(t, c, configHashAnnotation); !reflect.DeepEqual(annotations, expected) {
+				t.Errorf("expected config hashes %v, got %v", expected, annotations)
+			}
+
+			updated := &gatekeeperv1alpha1.Gogatekeeper{}
+			if err := c.Get(ctx, key, updated); err != nil {
+				t.Fatal(err)
+			}
+			if updated.Status.ConfigHash != hash {
+				t.Errorf("expected the config hash %s to be recorded, got %s", hash, updated.Status.ConfigHash)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+)
+
+// encryptionKeyLength is the length of generated encryption keys, gatekeeper requires 16 or 32 bytes
+const encryptionKeyLength = 32
+
+const encryptionKeyAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// lastRotatedAnnotation records on the encryption key Secret when its key was generated
+const lastRotatedAnnotation = "gatekeeper.theendbeta.me/last-rotated"
+
+// encryptionKeyRotatedAnnotation is set on the pod templates of injected workloads to the time the
+// encryption key was last rotated, restarting them with the new key
+const encryptionKeyRotatedAnnotation = "gatekeeper.theendbeta.me/encryption-key-rotated"
+
+// encryptionKeySecretName returns the name of the Secret holding a Gogatekeeper's generated encryption key
+func encryptionKeySecretName(gk *gatekeeperv1alpha1.Gogatekeeper) string {
+	return gk.Name + "-encryption-key"
+}
+
+// reconcileEncryptionKey makes sure a Gogatekeeper setting no encryption key has an owned Secret holding
+// a random encryption key. An existing valid key is only replaced when a rotation is due, and a Secret with the
+// same name that the Gogatekeeper does not control is left alone.
+// It returns how long until the Secret needs to be looked at again, or zero if it does not.
+func (r *GogatekeeperReconciler) reconcileEncryptionKey(ctx context.Context, gk *gatekeeperv1alpha1.Gogatekeeper) (time.Duration, error) {
+	log := log.FromContext(ctx)
+
+	if !gk.GeneratesEncryptionKey() {
+		gk.Status.EncryptionKeySecret = ""
+		gk.Status.LastRotated = nil
+		return 0, nil
+	}
+
+	now := r.now()
+	var requeueAfter time.Duration
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      encryptionKeySecretName(gk),
+			Namespace: gk.Namespace,
+		},
+	}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if err := ensureControlled(gk, secret, "Secret"); err != nil {
+			return err
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+
+		state := encryptionKeyStateAt(now, secret, gk.Spec.EncryptionKeyRotation)
+		if state.generate {
+			key, err := generateEncryptionKey()
+			if err != nil {
+				return err
+			}
+			if state.rotate {
+				log.Info("Rotating gogatekeeper encryption key", "Secret.Name", secret.Name, "Secret.Namespace", secret.Namespace)
+				secret.Data[gatekeeperv1alpha1.PreviousEncryptionKeySecretKey] = secret.Data[gatekeeperv1alpha1.EncryptionKeySecretKey]
+			}
+			secret.Data[gatekeeperv1alpha1.EncryptionKeySecretKey] = key
+		}
+		if state.dropPrevious {
+			delete(secret.Data, gatekeeperv1alpha1.PreviousEncryptionKeySecretKey)
+		}
+		secret.Annotations[lastRotatedAnnotation] = state.lastRotated.UTC().Format(time.RFC3339)
+		requeueAfter = state.requeueAfter
+
+		gk.Status.LastRotated = &metav1.Time{Time: state.lastRotated.UTC().Truncate(time.Second)}
+		return ctrl.SetControllerReference(gk, secret, r.Scheme)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		log.Info("Reconciled gogatekeeper encryption key", "Secret.Name", secret.Name, "Secret.Namespace", secret.Namespace, "Operation", op)
+	}
+
+	gk.Status.EncryptionKeySecret = secret.Name
+	return requeueAfter, nil
+}
+
+// encryptionKeyState is what has to be done to an encryption key Secret at a given time
+type encryptionKeyState struct {
+	// generate is set when a new key has to replace the current one
+	generate bool
+	// rotate is set when the replaced key is valid and has to be kept as the previous key
+	rotate bool
+	// dropPrevious is set when the grace period of the previous key is over
+	dropPrevious bool
+	// lastRotated is when the current key was generated, once the above has been applied
+	lastRotated time.Time
+	// requeueAfter is how long until the next rotation or the end of the grace period, zero if neither is pending
+	requeueAfter time.Duration
+}
+
+// encryptionKeyStateAt works out from the Secret's keys and rotation annotation whether its key has to be
+// generated or rotated at now, and whether the previous key's grace period is over
+func encryptionKeyStateAt(now time.Time, secret *corev1.Secret, rotation *gatekeeperv1alpha1.EncryptionKeyRotation) encryptionKeyState {
+	// The rotation time is kept on the Secret itself, so that it survives the status being lost
+	lastRotated, err := time.Parse(time.RFC3339, secret.Annotations[lastRotatedAnnotation])
+	if err != nil {
+		lastRotated = now
+	}
+
+	state := encryptionKeyState{}
+	currentKey := secret.Data[gatekeeperv1alpha1.EncryptionKeySecretKey]
+	state.rotate = rotation != nil && validEncryptionKey(currentKey) && !now.Before(lastRotated.Add(rotation.Interval.Duration))
+	state.generate = !validEncryptionKey(currentKey) || state.rotate
+	if state.generate {
+		lastRotated = now
+	}
+	state.lastRotated = lastRotated
+
+	if rotation != nil {
+		state.requeueAfter = lastRotated.Add(rotation.Interval.Duration).Sub(now)
+	}
+
+	// The replaced key is dropped once the grace period is over
+	if _, ok := secret.Data[gatekeeperv1alpha1.PreviousEncryptionKeySecretKey]; ok || state.rotate {
+		var grace time.Duration
+		if rotation != nil && rotation.GracePeriod != nil {
+			grace = rotation.GracePeriod.Duration
+		}
+		if graceLeft := lastRotated.Add(grace).Sub(now); graceLeft > 0 {
+			state.requeueAfter = minRequeue(state.requeueAfter, graceLeft)
+		} else {
+			state.dropPrevious = true
+		}
+	}
+
+	return state
+}
+
+// minRequeue returns the shortest of two requeue delays, ignoring zero delays
+func minRequeue(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func validEncryptionKey(key []byte) bool {
+	return len(key) == 16 || len(key) == 32
+}
+
+// generateEncryptionKey returns a cryptographically random alphanumeric encryption key
+func generateEncryptionKey() ([]byte, error) {
+	key := make([]byte, encryptionKeyLength)
+	max := big.NewInt(int64(len(encryptionKeyAlphabet)))
+	for i := range key {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		key[i] = encryptionKeyAlphabet[n.Int64()]
+	}
+	return key, nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+)
+
+func TestReconcileEncryptionKeyForeignSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatekeeperv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	gk := &gatekeeperv1alpha1.Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps"},
+	}
+	foreign := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk-encryption-key", Namespace: "apps"},
+		Data:       map[string][]byte{gatekeeperv1alpha1.EncryptionKeySecretKey: []byte("short")},
+	}
+	r := &GogatekeeperReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(foreign).Build(),
+		Scheme: scheme,
+	}
+	ctx := context.Background()
+
+	_, err := r.reconcileEncryptionKey(ctx, gk)
+	if _, ok := err.(*notOwnedError); !ok {
+		t.Fatalf("expected a *notOwnedError, got %v", err)
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "gk-encryption-key"}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.OwnerReferences) != 0 || len(secret.Annotations) != 0 || string(secret.Data[gatekeeperv1alpha1.EncryptionKeySecretKey]) != "short" {
+		t.Errorf("expected the foreign Secret to be left untouched, got %+v", secret)
+	}
+	if gk.Status.EncryptionKeySecret != "" || gk.Status.LastRotated != nil {
+		t.Errorf("expected no encryption key to be recorded, got %+v", gk.Status)
+	}
+}
+
+func TestEncryptionKeyStateAt(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	validKey := []byte("0123456789abcdef0123456789abcdef")
+	rotation := &gatekeeperv1alpha1.EncryptionKeyRotation{
+		Interval:    metav1.Duration{Duration: 24 * time.Hour},
+		GracePeriod: &metav1.Duration{Duration: time.Hour},
+	}
+
+	secret := func(lastRotated time.Time, key []byte, previous bool) *corev1.Secret {
+		s := &corev1.Secret{Data: map[string][]byte{}}
+		if !lastRotated.IsZero() {
+			s.Annotations = map[string]string{lastRotatedAnnotation: lastRotated.Format(time.RFC3339)}
+		}
+		if key != nil {
+			s.Data[gatekeeperv1alpha1.EncryptionKeySecretKey] = key
+		}
+		if previous {
+			s.Data[gatekeeperv1alpha1.PreviousEncryptionKeySecretKey] = validKey
+		}
+		return s
+	}
+
+	tests := []struct {
+		name     string
+		secret   *corev1.Secret
+		rotation *gatekeeperv1alpha1.EncryptionKeyRotation
+		expected encryptionKeyState
+	}{
+		{
+			name:     "first generation",
+			secret:   secret(time.Time{}, nil, false),
+			rotation: rotation,
+			expected: encryptionKeyState{generate: true, lastRotated: now, requeueAfter: 24 * time.Hour},
+		},
+		{
+			name:     "first generation without rotation",
+			secret:   secret(time.Time{}, nil, false),
+			expected: encryptionKeyState{generate: true, lastRotated: now},
+		},
+		{
+			name:     "rotation not due",
+			secret:   secret(now.Add(-20*time.Hour), validKey, false),
+			rotation: rotation,
+			expected: encryptionKeyState{lastRotated: now.Add(-20 * time.Hour), requeueAfter: 4 * time.Hour},
+		},
+		{
+			name:     "rotation due",
+			secret:   secret(now.Add(-25*time.Hour), validKey, false),
+			rotation: rotation,
+			expected: encryptionKeyState{generate: true, rotate: true, lastRotated: now, requeueAfter: time.Hour},
+		},
+		{
+			name:     "rotation due without a grace period",
+			secret:   secret(now.Add(-25*time.Hour), validKey, false),
+			rotation: &gatekeeperv1alpha1.EncryptionKeyRotation{Interval: rotation.Interval},
+			expected: encryptionKeyState{generate: true, rotate: true, dropPrevious: true, lastRotated: now, requeueAfter: 24 * time.Hour},
+		},
+		{
+			name:     "grace period running",
+			secret:   secret(now.Add(-20*time.Minute), validKey, true),
+			rotation: rotation,
+			expected: encryptionKeyState{lastRotated: now.Add(-20 * time.Minute), requeueAfter: 40 * time.Minute},
+		},
+		{
+			name:     "grace period expired",
+			secret:   secret(now.Add(-2*time.Hour), validKey, true),
+			rotation: rotation,
+			expected: encryptionKeyState{dropPrevious: true, lastRotated: now.Add(-2 * time.Hour), requeueAfter: 22 * time.Hour},
+		},
+		{
+			name:     "invalid existing key",
+			secret:   secret(now.Add(-time.Hour), []byte("short"), false),
+			rotation: rotation,
+			expected: encryptionKeyState{generate: true, lastRotated: now, requeueAfter: 24 * time.Hour},
+		},
+		{
+			name:     "rotation disabled",
+			secret:   secret(now.Add(-48*time.Hour), validKey, true),
+			expected: encryptionKeyState{dropPrevious: true, lastRotated: now.Add(-48 * time.Hour)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := encryptionKeyStateAt(now, tt.secret, tt.rotation)
+			if !state.lastRotated.Equal(tt.expected.lastRotated) {
+				t.Errorf("expected lastRotated %s, got %s", tt.expected.lastRotated, state.lastRotated)
+			}
+			state.lastRotated = tt.expected.lastRotated
+			if state != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, state)
+			}
+		})
+	}
+}
+
+func TestReconcileEncryptionKeySetInDefaultConfig(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatekeeperv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	gk := &gatekeeperv1alpha1.Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps"},
+		Spec:       gatekeeperv1alpha1.GogatekeeperSpec{DefaultConfig: "encryption-key: VtMkufTWXWA5x83C\n"},
+		Status:     gatekeeperv1alpha1.GogatekeeperStatus{EncryptionKeySecret: "gk-encryption-key"},
+	}
+	r := &GogatekeeperReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Scheme: scheme,
+	}
+	ctx := context.Background()
+
+	if _, err := r.reconcileEncryptionKey(ctx, gk); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "gk-encryption-key"}, &corev1.Secret{}); !errors.IsNotFound(err) {
+		t.Errorf("expected no encryption key Secret to be generated, got %v", err)
+	}
+	if gk.Status.EncryptionKeySecret != "" {
+		t.Errorf("expected no encryption key to be recorded, got %q", gk.Status.EncryptionKeySecret)
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+)
+
+// certificateGVK is the cert-manager Certificate kind. Certificates are handled as unstructured objects,
+// so that cert-manager only has to be installed when it is used.
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// gogatekeeperLabel is set on objects created for a workload, naming the Gogatekeeper they belong to
+const gogatekeeperLabel = "gatekeeper.theendbeta.me/gogatekeeper"
+
+// reconcileCertificates makes sure every workload injecting the Gogatekeeper has a cert-manager Certificate
+// when spec.tls.certManager is set, and removes the Certificates of workloads that no longer use it.
+// Certificates are only looked up while the CertificatesReady condition is set, as none can be left over otherwise.
+func (r *GogatekeeperReconciler) reconcileCertificates(ctx context.Context, gk *gatekeeperv1alpha1.Gogatekeeper) error {
+	log := log.FromContext(ctx)
+
+	usesCertManager := gk.Spec.TLS != nil && gk.Spec.TLS.CertManager != nil
+	if !usesCertManager && meta.FindStatusCondition(gk.Status.Conditions, gatekeeperv1alpha1.ConditionCertificatesReady) == nil {
+		return nil
+	}
+
+	wanted := map[string]bool{}
+	if usesCertManager {
+		workloads, err := r.injectedWorkloads(ctx, gk)
+		if err != nil {
+			return err
+		}
+
+		for _, w := range workloads {
+			name := gatekeeperv1alpha1.CertificateSecretName(w.obj.GetName())
+			if wanted[name] {
+				continue
+			}
+			wanted[name] = true
+
+			certificate := &unstructured.Unstructured{}
+			certificate.SetGroupVersionKind(certificateGVK)
+			certificate.SetName(name)
+			certificate.SetNamespace(gk.Namespace)
+			op, err := controllerutil.CreateOrUpdate(ctx, r.Client, certificate, func() error {
+				return r.mutateCertificate(gk, certificate, w.obj.GetName())
+			})
+			if _, ok := err.(*notOwnedError); ok {
+				return err
+			} else if err != nil {
+				return fmt.Errorf("reconciling Certificate %s: %w", name, err)
+			}
+			if op != controllerutil.OperationResultNone {
+				log.Info("Reconciled gatekeeper Certificate", "Certificate.Name", name, "Workload", w.obj.GetName(), "Operation", op)
+			}
+		}
+	}
+
+	certificates := &unstructured.UnstructuredList{}
+	certificates.SetGroupVersionKind(certificateGVK.GroupVersion().WithKind(certificateGVK.Kind + "List"))
+	err := r.List(ctx, certificates, client.InNamespace(gk.Namespace), client.MatchingLabels{gogatekeeperLabel: gk.Name})
+	if err != nil {
+		// Nothing can be left to clean up when cert-manager is not installed
+		if meta.IsNoMatchError(err) && len(wanted) == 0 {
+			return nil
+		}
+		return err
+	}
+	for i := range certificates.Items {
+		certificate := &certificates.Items[i]
+		if wanted[certificate.GetName()] || !metav1.IsControlledBy(certificate, gk) {
+			continue
+		}
+		log.Info("Deleting unused gatekeeper Certificate", "Certificate.Name", certificate.GetName())
+		if err := r.Delete(ctx, certificate); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mutateCertificate sets the fields of a workload's Certificate managed by the operator, refusing to take over
+// a Certificate with the same name that the Gogatekeeper does not control
+func (r *GogatekeeperReconciler) mutateCertificate(gk *gatekeeperv1alpha1.Gogatekeeper, certificate *unstructured.Unstructured, workloadName string) error {
+	if err := ensureControlled(gk, certificate, "Certificate"); err != nil {
+		return err
+	}
+	certManager := gk.Spec.TLS.CertManager
+
+	labels := certificate.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[gogatekeeperLabel] = gk.Name
+	certificate.SetLabels(labels)
+
+	dnsNames := []string{
+		workloadName,
+		fmt.Sprintf("%s.%s.svc", workloadName, gk.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", workloadName, gk.Namespace),
+	}
+	dnsNames = append(dnsNames, certManager.DNSNames...)
+
+	issuerKind := certManager.IssuerRef.Kind
+	if issuerKind == "" {
+		issuerKind = "Issuer"
+	}
+	issuerGroup := certManager.IssuerRef.Group
+	if issuerGroup == "" {
+		issuerGroup = certificateGVK.Group
+	}
+
+	fields := []struct {
+		value interface{}
+		path  []string
+	}{
+		{gatekeeperv1alpha1.CertificateSecretName(workloadName), []string{"spec", "secretName"}},
+		{certManager.IssuerRef.Name, []string{"spec", "issuerRef", "name"}},
+		{issuerKind, []string{"spec", "issuerRef", "kind"}},
+		{issuerGroup, []string{"spec", "issuerRef", "group"}},
+	}
+	for _, f := range fields {
+		if err := unstructured.SetNestedField(certificate.Object, f.value, f.path...); err != nil {
+			return err
+		}
+	}
+	if err := unstructured.SetNestedStringSlice(certificate.Object, dnsNames, "spec", "dnsNames"); err != nil {
+		return err
+	}
+
+	return ctrl.SetControllerReference(gk, certificate, r.Scheme)
+}
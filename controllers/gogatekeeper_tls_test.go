@@ -0,0 +1,208 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gatekeeperv1alpha1 "github.com/theEndBeta/gogatekeeper-operator/api/v1alpha1"
+)
+
+// newTLSTestScheme returns a scheme knowing cert-manager Certificates as unstructured objects
+func newTLSTestScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatekeeperv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	scheme.AddKnownTypeWithName(certificateGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(certificateGVK.GroupVersion().WithKind(certificateGVK.Kind+"List"), &unstructured.UnstructuredList{})
+	return scheme
+}
+
+// newCertificate returns a Certificate with the given name, labelled for the Gogatekeeper gk
+func newCertificate(name string) *unstructured.Unstructured {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetName(name)
+	certificate.SetNamespace("apps")
+	certificate.SetLabels(map[string]string{gogatekeeperLabel: "gk"})
+	return certificate
+}
+
+func TestReconcileForeignCertificate(t *testing.T) {
+	scheme := newTLSTestScheme(t)
+	gk := &gatekeeperv1alpha1.Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps"},
+		Spec: gatekeeperv1alpha1.GogatekeeperSpec{TLS: &gatekeeperv1alpha1.TLSSpec{
+			CertManager: &gatekeeperv1alpha1.CertManagerTLS{IssuerRef: gatekeeperv1alpha1.IssuerReference{Name: "ca-issuer"}},
+		}},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{gatekeeperAnnotation: "gk"}},
+		}},
+	}
+	foreign := newCertificate("app-gatekeeper-tls")
+	foreign.SetLabels(nil)
+	if err := unstructured.SetNestedField(foreign.Object, "someone-elses-issuer", "spec", "issuerRef", "name"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &GogatekeeperReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment, foreign).Build(),
+		Scheme: scheme,
+	}
+	ctx := context.Background()
+
+	if _, ok := r.reconcileCertificates(ctx, gk).(*notOwnedError); !ok {
+		t.Fatal("expected reconciling into a foreign Certificate to fail with a *notOwnedError")
+	}
+
+	certificate := newCertificate("")
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "app-gatekeeper-tls"}, certificate); err != nil {
+		t.Fatal(err)
+	}
+	issuer, _, _ := unstructured.NestedString(certificate.Object, "spec", "issuerRef", "name")
+	if len(certificate.GetOwnerReferences()) != 0 || len(certificate.GetLabels()) != 0 || issuer != "someone-elses-issuer" {
+		t.Errorf("expected the foreign Certificate to be left untouched, got %v", certificate.Object)
+	}
+}
+
+// listCounter counts the List calls made through a client
+type listCounter struct {
+	client.Client
+	lists int
+}
+
+func (c *listCounter) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	c.lists++
+	return c.Client.List(ctx, list, opts...)
+}
+
+func TestReconcileCertificates(t *testing.T) {
+	scheme := newTLSTestScheme(t)
+	gk := &gatekeeperv1alpha1.Gogatekeeper{
+		ObjectMeta: metav1.ObjectMeta{Name: "gk", Namespace: "apps", UID: "gk-uid"},
+		Spec: gatekeeperv1alpha1.GogatekeeperSpec{TLS: &gatekeeperv1alpha1.TLSSpec{
+			CertManager: &gatekeeperv1alpha1.CertManagerTLS{
+				IssuerRef: gatekeeperv1alpha1.IssuerReference{Name: "ca-issuer", Kind: "ClusterIssuer"},
+				DNSNames:  []string{"app.example.com"},
+			},
+		}},
+	}
+	injected := corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{gatekeeperAnnotation: "gk"}}}
+	app := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps"},
+		Spec:       appsv1.DeploymentSpec{Template: injected},
+	}
+	db := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "apps"},
+		Spec:       appsv1.StatefulSetSpec{Template: injected},
+	}
+	other := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "apps"}}
+
+	// The Certificate of a workload that stopped using the Gogatekeeper, and one only carrying its label
+	unused := newCertificate("old-gatekeeper-tls")
+	if err := ctrl.SetControllerReference(gk, unused, scheme); err != nil {
+		t.Fatal(err)
+	}
+	labelled := newCertificate("manual-gatekeeper-tls")
+
+	c := &listCounter{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(app, db, other, unused, labelled).Build()}
+	r := &GogatekeeperReconciler{Client: c, Scheme: scheme}
+	ctx := context.Background()
+
+	exists := func(name string) bool {
+		t.Helper()
+		err := r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: name}, newCertificate(""))
+		if client.IgnoreNotFound(err) != nil {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	if err := r.reconcileCertificates(ctx, gk); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workload := range []string{"app", "db"} {
+		certificate := newCertificate("")
+		if err := r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: workload + "-gatekeeper-tls"}, certificate); err != nil {
+			t.Fatalf("expected a Certificate for %s: %v", workload, err)
+		}
+		spec, _, _ := unstructured.NestedMap(certificate.Object, "spec")
+		expected := map[string]interface{}{
+			"secretName": workload + "-gatekeeper-tls",
+			"issuerRef":  map[string]interface{}{"name": "ca-issuer", "kind": "ClusterIssuer", "group": "cert-manager.io"},
+			"dnsNames": []interface{}{
+				workload, workload + ".apps.svc", workload + ".apps.svc.cluster.local", "app.example.com",
+			},
+		}
+		if !reflect.DeepEqual(spec, expected) {
+			t.Errorf("expected the %s Certificate spec %v, got %v", workload, expected, spec)
+		}
+		if certificate.GetLabels()[gogatekeeperLabel] != "gk" || !metav1.IsControlledBy(certificate, gk) {
+			t.Errorf("expected the %s Certificate to be labelled and controlled by the Gogatekeeper, got %v", workload, certificate.Object["metadata"])
+		}
+	}
+	if exists("other-gatekeeper-tls") {
+		t.Error("expected no Certificate for a workload not using the Gogatekeeper")
+	}
+	if exists("old-gatekeeper-tls") {
+		t.Error("expected the unused Certificate to be deleted")
+	}
+	if !exists("manual-gatekeeper-tls") {
+		t.Error("expected a Certificate not controlled by the Gogatekeeper to be kept")
+	}
+
+	// Dropping certManager cleans up every Certificate while the condition is still set
+	gk.Spec.TLS = nil
+	gk.Status.Conditions = []metav1.Condition{{Type: gatekeeperv1alpha1.ConditionCertificatesReady, Status: metav1.ConditionTrue}}
+	if err := r.reconcileCertificates(ctx, gk); err != nil {
+		t.Fatal(err)
+	}
+	if exists("app-gatekeeper-tls") || exists("db-gatekeeper-tls") {
+		t.Error("expected the Certificates to be deleted once certManager is unset")
+	}
+
+	// After which Certificates are no longer listed
+	gk.Status.Conditions = nil
+	c.lists = 0
+	if err := r.reconcileCertificates(ctx, gk); err != nil {
+		t.Fatal(err)
+	}
+	if c.lists != 0 {
+		t.Errorf("expected no List calls without certManager, got %d", c.lists)
+	}
+}
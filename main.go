@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -53,11 +54,22 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var providerCheckInterval time.Duration
+	var injectorOptions gatekeeperv1alpha1.InjectorOptions
+	var sidecarCPURequest, sidecarCPULimit, sidecarMemoryRequest, sidecarMemoryLimit string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&providerCheckInterval, "provider-check-interval", controllers.DefaultProviderCheckInterval,
+		"How often the OIDC provider of each Gogatekeeper is probed.")
+	flag.StringVar(&injectorOptions.Image, "gatekeeper-image", gatekeeperv1alpha1.DefaultGatekeeperImage,
+		"The gatekeeper image injected into pods whose Gogatekeeper does not set one.")
+	flag.StringVar(&sidecarCPURequest, "sidecar-cpu-request", "10m", "Default CPU request of injected gatekeeper containers.")
+	flag.StringVar(&sidecarCPULimit, "sidecar-cpu-limit", "", "Default CPU limit of injected gatekeeper containers.")
+	flag.StringVar(&sidecarMemoryRequest, "sidecar-memory-request", "32Mi", "Default memory request of injected gatekeeper containers.")
+	flag.StringVar(&sidecarMemoryLimit, "sidecar-memory-limit", "128Mi", "Default memory limit of injected gatekeeper containers.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -66,6 +78,13 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	var err error
+	injectorOptions.Resources, err = gatekeeperv1alpha1.ParseResources(sidecarCPURequest, sidecarCPULimit, sidecarMemoryRequest, sidecarMemoryLimit)
+	if err != nil {
+		setupLog.Error(err, "invalid sidecar resources")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -80,8 +99,10 @@ func main() {
 	}
 
 	if err = (&controllers.GogatekeeperReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                mgr.GetClient(),
+		Scheme:                mgr.GetScheme(),
+		Recorder:              mgr.GetEventRecorderFor("gogatekeeper-controller"),
+		ProviderCheckInterval: providerCheckInterval,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Gogatekeeper")
 		os.Exit(1)
@@ -89,8 +110,10 @@ func main() {
 
 	setupLog.Info("setting up webhook server")
 	hookServer := mgr.GetWebhookServer()
-	gkInjector := gatekeeperv1alpha1.NewGatekeeperInjector(mgr.GetClient())
+	gkInjector := gatekeeperv1alpha1.NewGatekeeperInjector(mgr.GetClient(), injectorOptions)
 	hookServer.Register("/mutate-v1-pod", &webhook.Admission{Handler: gkInjector})
+	gkValidator := gatekeeperv1alpha1.NewGogatekeeperValidator()
+	hookServer.Register("/validate-gatekeeper-theendbeta-me-v1alpha1-gogatekeeper", &webhook.Admission{Handler: gkValidator})
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oidc fetches the metadata and signing keys published by OpenID Connect providers.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DiscoveryPath is the path of the discovery document, relative to the provider URL
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// maxDocumentSize limits how much of a provider response is read
+const maxDocumentSize = 1 << 20
+
+// Discovery holds the fields of a provider's discovery document used by gatekeeper
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// DiscoveryURL returns the URL of the discovery document of the provider at providerURL
+func DiscoveryURL(providerURL string) string {
+	return strings.TrimSuffix(providerURL, "/") + DiscoveryPath
+}
+
+// Discover fetches and checks the discovery document of the provider at providerURL.
+// Like gatekeeper, it requires the advertised issuer to match the provider URL.
+func Discover(ctx context.Context, client *http.Client, providerURL string) (*Discovery, error) {
+	discovery := &Discovery{}
+	if err := getJSON(ctx, client, DiscoveryURL(providerURL), discovery); err != nil {
+		return nil, err
+	}
+
+	missing := []string{}
+	for name, value := range map[string]string{
+		"issuer":                 discovery.Issuer,
+		"authorization_endpoint": discovery.AuthorizationEndpoint,
+		"token_endpoint":         discovery.TokenEndpoint,
+		"jwks_uri":               discovery.JWKSURI,
+	} {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("discovery document is missing %s", strings.Join(sorted(missing), ", "))
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(providerURL, "/") {
+		return nil, fmt.Errorf("issuer %q does not match provider URL %q", discovery.Issuer, providerURL)
+	}
+	return discovery, nil
+}
+
+// KeyIDs fetches the JSON Web Key Set at jwksURI and returns the sorted IDs of its keys.
+// Keys without an ID are ignored.
+func KeyIDs(ctx context.Context, client *http.Client, jwksURI string) ([]string, error) {
+	jwks := &struct {
+		Keys []struct {
+			KeyID string `json:"kid"`
+		} `json:"keys"`
+	}{}
+	if err := getJSON(ctx, client, jwksURI, jwks); err != nil {
+		return nil, err
+	}
+	if len(jwks.Keys) == 0 {
+		return nil, fmt.Errorf("key set at %s holds no keys", jwksURI)
+	}
+
+	ids := []string{}
+	for _, key := range jwks.Keys {
+		if key.KeyID != "" {
+			ids = append(ids, key.KeyID)
+		}
+	}
+	return sorted(ids), nil
+}
+
+// getJSON decodes the JSON document at url into v
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(v); err != nil {
+		return fmt.Errorf("GET %s: invalid JSON: %v", url, err)
+	}
+	return nil
+}
+
+func sorted(values []string) []string {
+	sort.Strings(values)
+	return values
+}
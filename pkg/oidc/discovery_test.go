@@ -0,0 +1,159 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// newProvider starts a stand-in provider serving the discovery document returned by document
+func newProvider(t *testing.T, status int, document func(url string) string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DiscoveryPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, document(server.URL))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func validDocument(url string) string {
+	return fmt.Sprintf(`{"issuer": %q, "authorization_endpoint": "%[1]s/auth", "token_endpoint": "%[1]s/token", "jwks_uri": "%[1]s/keys"}`, url)
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		document func(url string) string
+		valid    bool
+	}{
+		{
+			name:     "valid",
+			status:   http.StatusOK,
+			document: validDocument,
+			valid:    true,
+		},
+		{
+			name:     "not found",
+			status:   http.StatusNotFound,
+			document: validDocument,
+		},
+		{
+			name:     "invalid json",
+			status:   http.StatusOK,
+			document: func(string) string { return "<html></html>" },
+		},
+		{
+			name:     "missing endpoints",
+			status:   http.StatusOK,
+			document: func(url string) string { return fmt.Sprintf(`{"issuer": %q}`, url) },
+		},
+		{
+			name:     "issuer mismatch",
+			status:   http.StatusOK,
+			document: func(string) string { return validDocument("https://other.example.com") },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newProvider(t, test.status, test.document)
+
+			discovery, err := Discover(context.Background(), server.Client(), server.URL)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", discovery)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if discovery.Issuer != server.URL || discovery.JWKSURI != server.URL+"/keys" {
+				t.Errorf("unexpected discovery document %+v", discovery)
+			}
+		})
+	}
+}
+
+func TestDiscoverTrailingSlash(t *testing.T) {
+	server := newProvider(t, http.StatusOK, validDocument)
+
+	if _, err := Discover(context.Background(), server.Client(), server.URL+"/"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKeyIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		document string
+		expected []string
+	}{
+		{
+			name:     "sorted ids",
+			status:   http.StatusOK,
+			document: `{"keys": [{"kid": "b", "kty": "RSA"}, {"kty": "RSA"}, {"kid": "a", "kty": "EC"}]}`,
+			expected: []string{"a", "b"},
+		},
+		{
+			name:     "empty key set",
+			status:   http.StatusOK,
+			document: `{"keys": []}`,
+		},
+		{
+			name:     "server error",
+			status:   http.StatusInternalServerError,
+			document: `{"keys": [{"kid": "a"}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.document)
+			}))
+			defer server.Close()
+
+			ids, err := KeyIDs(context.Background(), server.Client(), server.URL+"/keys")
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("expected an error, got %v", ids)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ids, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, ids)
+			}
+		})
+	}
+}
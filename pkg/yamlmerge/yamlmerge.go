@@ -0,0 +1,390 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package yamlmerge deep-merges yaml documents, keeping the comments of the document being merged into.
+package yamlmerge
+
+import (
+	"fmt"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Strategy defines how a mapping or sequence in the overlay is combined with the base
+type Strategy string
+
+const (
+	// Replace replaces the base value with the overlay value
+	Replace Strategy = "Replace"
+	// Merge merges mappings key by key and appends sequence items missing from the base
+	Merge Strategy = "Merge"
+)
+
+// Merger merges an overlay mapping into a base mapping.
+// Scalars in the overlay always override the base.
+type Merger struct {
+	// Strategies used for mappings and sequences, keyed by their dotted path (e.g. "headers")
+	Strategies map[string]Strategy
+	// Strategy used for mappings and sequences without an entry in Strategies
+	Default Strategy
+	// Keys identifying the items of merged sequences of mappings, keyed by the sequence's dotted path.
+	// Overlay items replace the base items with the same values for all of the keys, an unset key only
+	// matching items where it is unset too, rather than being appended. Sequence values of a key are compared
+	// as sets, regardless of the order of their items.
+	ListKeys map[string][]string
+}
+
+// Mapping returns the top-level mapping of a parsed document.
+// An empty document is turned into a document holding an empty mapping.
+func Mapping(doc *yamlv3.Node) (*yamlv3.Node, error) {
+	if doc.Kind == 0 || (doc.Kind == yamlv3.DocumentNode && len(doc.Content) == 0) {
+		doc.Kind = yamlv3.DocumentNode
+		doc.Content = []*yamlv3.Node{{Kind: yamlv3.MappingNode, Tag: "!!map"}}
+	}
+
+	node := doc
+	if doc.Kind == yamlv3.DocumentNode {
+		node = doc.Content[0]
+	}
+
+	if node.Kind != yamlv3.MappingNode {
+		return nil, fmt.Errorf("expected a mapping at the top level, got %s", kindName(node.Kind))
+	}
+	return node, nil
+}
+
+// Merge merges overlay into base, modifying base in place.
+// It returns the dotted paths of the base values that were overridden by the overlay.
+// Aliases in base referring to a value that is modified are replaced by a copy of the original value,
+// so that they keep the value they were written with.
+func (m *Merger) Merge(base, overlay *yamlv3.Node) ([]string, error) {
+	baseMap, err := Mapping(base)
+	if err != nil {
+		return nil, err
+	}
+	overlayMap, err := Mapping(overlay)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &mergeState{Merger: m, aliases: map[*yamlv3.Node][]*yamlv3.Node{}, overridden: []string{}}
+	state.collectAliases(baseMap)
+	state.mergeMapping(baseMap, overlayMap, "", []*yamlv3.Node{baseMap})
+	return state.overridden, nil
+}
+
+func (m *Merger) strategy(path string) Strategy {
+	if s, ok := m.Strategies[path]; ok {
+		return s
+	}
+	if m.Default == "" {
+		return Merge
+	}
+	return m.Default
+}
+
+// mergeState holds what is tracked during a single merge
+type mergeState struct {
+	*Merger
+	// Alias nodes of the base document, keyed by the anchored node they refer to
+	aliases    map[*yamlv3.Node][]*yamlv3.Node
+	overridden []string
+}
+
+// mergeMapping merges an overlay mapping into a base mapping. ancestors holds the base nodes from the
+// top-level mapping down to base, which are detached from their aliases before being modified.
+func (s *mergeState) mergeMapping(base, overlay *yamlv3.Node, prefix string, ancestors []*yamlv3.Node) {
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+
+		baseValue := lookup(base, key.Value)
+		if baseValue == nil {
+			s.detach(ancestors)
+			base.Content = append(base.Content, key, value)
+			continue
+		}
+		if duplicated(base, key.Value) {
+			s.detach(ancestors)
+			removeDuplicates(base, key.Value)
+		}
+
+		// Values merged into an alias are merged into a copy of the aliased value instead
+		if baseValue.Kind == yamlv3.AliasNode && baseValue.Alias != nil && baseValue.Alias.Kind == value.Kind && value.Kind != yamlv3.ScalarNode {
+			s.expand(baseValue)
+		}
+
+		valueAncestors := append(ancestors[:len(ancestors):len(ancestors)], baseValue)
+		switch {
+		case baseValue.Kind == yamlv3.MappingNode && value.Kind == yamlv3.MappingNode && s.strategy(path) == Merge:
+			s.mergeMapping(baseValue, value, path, valueAncestors)
+		case baseValue.Kind == yamlv3.SequenceNode && value.Kind == yamlv3.SequenceNode && s.strategy(path) == Merge:
+			s.mergeSequence(baseValue, value, path, valueAncestors)
+		default:
+			if !Equal(baseValue, value) {
+				s.overridden = append(s.overridden, path)
+				s.detach(valueAncestors)
+				replace(baseValue, value)
+			}
+		}
+	}
+}
+
+func (s *mergeState) mergeSequence(base, overlay *yamlv3.Node, path string, ancestors []*yamlv3.Node) {
+	listKeys := s.ListKeys[path]
+	for _, item := range overlay.Content {
+		if contains(base, item) {
+			continue
+		}
+
+		if len(listKeys) > 0 && item.Kind == yamlv3.MappingNode {
+			if matches := findItems(base, item, listKeys); len(matches) > 0 {
+				s.overridden = append(s.overridden, fmt.Sprintf("%s[%s]", path, keyString(item, listKeys)))
+				s.detach(append(ancestors[:len(ancestors):len(ancestors)], matches[0]))
+				replace(matches[0], item)
+				if len(matches) > 1 {
+					s.detach(ancestors)
+					removeItems(base, matches[1:])
+				}
+				continue
+			}
+		}
+
+		s.detach(ancestors)
+		base.Content = append(base.Content, item)
+	}
+}
+
+// collectAliases records the alias nodes under node
+func (s *mergeState) collectAliases(node *yamlv3.Node) {
+	if node.Kind == yamlv3.AliasNode && node.Alias != nil {
+		s.aliases[node.Alias] = append(s.aliases[node.Alias], node)
+	}
+	for _, child := range node.Content {
+		s.collectAliases(child)
+	}
+}
+
+// detach replaces the aliases of the given nodes with copies of their current value before they are modified,
+// dropping the anchors no longer referred to
+func (s *mergeState) detach(nodes []*yamlv3.Node) {
+	for _, node := range nodes {
+		for _, alias := range s.aliases[node] {
+			// The alias itself may have been overridden since
+			if alias.Kind == yamlv3.AliasNode && alias.Alias == node {
+				s.expand(alias)
+			}
+		}
+		if _, ok := s.aliases[node]; ok {
+			node.Anchor = ""
+			delete(s.aliases, node)
+		}
+	}
+}
+
+// expand replaces an alias node with a copy of the value it refers to, keeping its comments
+func (s *mergeState) expand(alias *yamlv3.Node) {
+	head, line, foot := alias.HeadComment, alias.LineComment, alias.FootComment
+	*alias = *s.copyNode(alias.Alias)
+	alias.HeadComment, alias.LineComment, alias.FootComment = head, line, foot
+}
+
+// copyNode deep copies a node without its anchors, recording the aliases in the copy
+func (s *mergeState) copyNode(node *yamlv3.Node) *yamlv3.Node {
+	copied := *node
+	copied.Anchor = ""
+	copied.Content = make([]*yamlv3.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = s.copyNode(child)
+	}
+	if copied.Kind == yamlv3.AliasNode && copied.Alias != nil {
+		s.aliases[copied.Alias] = append(s.aliases[copied.Alias], &copied)
+	}
+	return &copied
+}
+
+// findItems returns the mappings in a sequence with the same values as item for all of the keys
+func findItems(sequence, item *yamlv3.Node, keys []string) []*yamlv3.Node {
+	matches := []*yamlv3.Node{}
+	for _, existing := range sequence.Content {
+		if existing.Kind != yamlv3.MappingNode {
+			continue
+		}
+		matched := true
+		for _, key := range keys {
+			a, b := lookup(existing, key), lookup(item, key)
+			if (a == nil) != (b == nil) || (a != nil && !equalKey(a, b)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, existing)
+		}
+	}
+	return matches
+}
+
+// equalKey reports whether two values of a list key are the same, comparing sequences as sets
+func equalKey(a, b *yamlv3.Node) bool {
+	if a.Kind != yamlv3.SequenceNode || b.Kind != yamlv3.SequenceNode {
+		return Equal(a, b)
+	}
+	for _, item := range a.Content {
+		if !contains(b, item) {
+			return false
+		}
+	}
+	for _, item := range b.Content {
+		if !contains(a, item) {
+			return false
+		}
+	}
+	return true
+}
+
+// removeItems drops the given items from a sequence
+func removeItems(sequence *yamlv3.Node, items []*yamlv3.Node) {
+	content := []*yamlv3.Node{}
+	for _, existing := range sequence.Content {
+		removed := false
+		for _, item := range items {
+			if existing == item {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			content = append(content, existing)
+		}
+	}
+	sequence.Content = content
+}
+
+// keyString describes the values of the keys set on a mapping, e.g. "uri=/admin,methods=[GET,POST]"
+func keyString(item *yamlv3.Node, keys []string) string {
+	parts := []string{}
+	for _, key := range keys {
+		value := lookup(item, key)
+		if value == nil {
+			continue
+		}
+		if value.Kind != yamlv3.SequenceNode {
+			parts = append(parts, key+"="+value.Value)
+			continue
+		}
+		values := []string{}
+		for _, v := range value.Content {
+			values = append(values, v.Value)
+		}
+		parts = append(parts, key+"=["+strings.Join(values, ",")+"]")
+	}
+	return strings.Join(parts, ",")
+}
+
+// lookup returns the value for key in a mapping node, or nil if it is not set
+func lookup(mapping *yamlv3.Node, key string) *yamlv3.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// duplicated reports whether key is set more than once in a mapping node
+func duplicated(mapping *yamlv3.Node, key string) bool {
+	count := 0
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			count++
+		}
+	}
+	return count > 1
+}
+
+// removeDuplicates drops all but the first occurrence of key in a mapping node
+func removeDuplicates(mapping *yamlv3.Node, key string) {
+	content := []*yamlv3.Node{}
+	seen := false
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			if seen {
+				continue
+			}
+			seen = true
+		}
+		content = append(content, mapping.Content[i], mapping.Content[i+1])
+	}
+	mapping.Content = content
+}
+
+func contains(sequence, item *yamlv3.Node) bool {
+	for _, existing := range sequence.Content {
+		if Equal(existing, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// replace overwrites the base node with the overlay node, keeping the comments of the base
+func replace(base, overlay *yamlv3.Node) {
+	head, line, foot := base.HeadComment, base.LineComment, base.FootComment
+	*base = *overlay
+	if base.HeadComment == "" {
+		base.HeadComment = head
+	}
+	if base.LineComment == "" {
+		base.LineComment = line
+	}
+	if base.FootComment == "" {
+		base.FootComment = foot
+	}
+}
+
+// Equal reports whether two nodes hold the same data, ignoring style and comments
+func Equal(a, b *yamlv3.Node) bool {
+	if a.Kind != b.Kind || a.Value != b.Value || a.ShortTag() != b.ShortTag() || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !Equal(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func kindName(kind yamlv3.Kind) string {
+	switch kind {
+	case yamlv3.DocumentNode:
+		return "document"
+	case yamlv3.SequenceNode:
+		return "sequence"
+	case yamlv3.MappingNode:
+		return "mapping"
+	case yamlv3.ScalarNode:
+		return "scalar"
+	case yamlv3.AliasNode:
+		return "alias"
+	}
+	return fmt.Sprintf("kind %d", kind)
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package yamlmerge
+
+import (
+	"reflect"
+	"testing"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name       string
+		base       string
+		overlay    string
+		strategies map[string]Strategy
+		listKeys   map[string][]string
+		expected   string
+		overridden []string
+	}{
+		{
+			name:       "empty base",
+			base:       "",
+			overlay:    "discovery-url: http://idp\n",
+			expected:   "discovery-url: http://idp\n",
+			overridden: []string{},
+		},
+		{
+			name:       "scalar override keeps comments",
+			base:       "# provider\ndiscovery-url: http://old # old value\nlisten: :3000\n",
+			overlay:    "discovery-url: http://idp\n",
+			expected:   "# provider\ndiscovery-url: http://idp # old value\nlisten: :3000\n",
+			overridden: []string{"discovery-url"},
+		},
+		{
+			name:       "duplicate keys are collapsed",
+			base:       "discovery-url: http://old\ndiscovery-url: http://older\n",
+			overlay:    "discovery-url: http://idp\n",
+			expected:   "discovery-url: http://idp\n",
+			overridden: []string{"discovery-url"},
+		},
+		{
+			name:       "maps are merged",
+			base:       "headers:\n  a: \"1\"\n  b: \"2\"\n",
+			overlay:    "headers:\n  b: \"3\"\n  c: \"4\"\n",
+			expected:   "headers:\n    a: \"1\"\n    b: \"3\"\n    c: \"4\"\n",
+			overridden: []string{"headers.b"},
+		},
+		{
+			name:       "lists are appended",
+			base:       "scopes:\n  - email\n  - groups\n",
+			overlay:    "scopes:\n  - groups\n  - profile\n",
+			expected:   "scopes:\n    - email\n    - groups\n    - profile\n",
+			overridden: []string{},
+		},
+		{
+			name:       "replace strategy",
+			base:       "match-claims:\n  aud: app\n",
+			overlay:    "match-claims:\n  iss: idp\n",
+			strategies: map[string]Strategy{"match-claims": Replace},
+			expected:   "match-claims:\n    iss: idp\n",
+			overridden: []string{"match-claims"},
+		},
+		{
+			name:       "keyed lists are merged by key",
+			base:       "resources:\n  - uri: /admin\n    roles: [user]\n  - uri: /public\n    white-listed: true\n",
+			overlay:    "resources:\n  - uri: /admin\n    roles: [admin]\n  - uri: /api\n",
+			listKeys:   map[string][]string{"resources": {"uri"}},
+			expected:   "resources:\n    - uri: /admin\n      roles: [admin]\n    - uri: /public\n      white-listed: true\n    - uri: /api\n",
+			overridden: []string{"resources[uri=/admin]"},
+		},
+		{
+			name:       "lists keyed by several keys",
+			base:       "resources:\n  - uri: /admin\n    methods: [GET]\n    roles: [reader]\n  - uri: /admin\n    methods: [POST]\n    roles: [writer]\n",
+			overlay:    "resources:\n  - uri: /admin\n    methods: [POST]\n    roles: [admin]\n  - uri: /admin\n    roles: [auditor]\n",
+			listKeys:   map[string][]string{"resources": {"uri", "methods"}},
+			expected:   "resources:\n    - uri: /admin\n      methods: [GET]\n      roles: [reader]\n    - uri: /admin\n      methods: [POST]\n      roles: [admin]\n    - uri: /admin\n      roles: [auditor]\n",
+			overridden: []string{"resources[uri=/admin,methods=[POST]]"},
+		},
+		{
+			name:       "list keys holding sequences are compared as sets",
+			base:       "resources:\n  - uri: /admin\n    methods: [GET, POST]\n    roles: [reader]\n",
+			overlay:    "resources:\n  - uri: /admin\n    methods: [POST, GET]\n    roles: [admin]\n",
+			listKeys:   map[string][]string{"resources": {"uri", "methods"}},
+			expected:   "resources:\n    - uri: /admin\n      methods: [POST, GET]\n      roles: [admin]\n",
+			overridden: []string{"resources[uri=/admin,methods=[POST,GET]]"},
+		},
+		{
+			name:       "every item with the same keys is replaced",
+			base:       "resources:\n  - uri: /admin\n    roles: [user]\n  - uri: /public\n  - uri: /admin\n    roles: [other]\n",
+			overlay:    "resources:\n  - uri: /admin\n    roles: [admin]\n",
+			listKeys:   map[string][]string{"resources": {"uri", "methods"}},
+			expected:   "resources:\n    - uri: /admin\n      roles: [admin]\n    - uri: /public\n",
+			overridden: []string{"resources[uri=/admin]"},
+		},
+		{
+			name:       "aliases of a replaced anchor keep its value",
+			base:       "listen: &l :3000\nlisten-admin: *l\n",
+			overlay:    "listen: :8080\n",
+			expected:   "listen: :8080\nlisten-admin: :3000\n",
+			overridden: []string{"listen"},
+		},
+		{
+			name:       "aliases of a merged anchor keep its value",
+			base:       "headers: &h\n  a: \"1\"\nresponse-headers: *h\n",
+			overlay:    "headers:\n  b: \"2\"\n",
+			expected:   "headers:\n    a: \"1\"\n    b: \"2\"\nresponse-headers:\n    a: \"1\"\n",
+			overridden: []string{},
+		},
+		{
+			name:       "merging into an alias copies it",
+			base:       "headers: &h\n  a: \"1\"\nresponse-headers: *h\n",
+			overlay:    "response-headers:\n  b: \"2\"\n",
+			expected:   "headers: &h\n    a: \"1\"\nresponse-headers:\n    a: \"1\"\n    b: \"2\"\n",
+			overridden: []string{},
+		},
+		{
+			name:       "unmodified anchors are kept",
+			base:       "listen: &l :3000\nlisten-admin: *l\n",
+			overlay:    "discovery-url: http://idp\n",
+			expected:   "listen: &l :3000\nlisten-admin: *l\ndiscovery-url: http://idp\n",
+			overridden: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := &yamlv3.Node{}
+			if err := yamlv3.Unmarshal([]byte(test.base), base); err != nil {
+				t.Fatal(err)
+			}
+			overlay := &yamlv3.Node{}
+			if err := yamlv3.Unmarshal([]byte(test.overlay), overlay); err != nil {
+				t.Fatal(err)
+			}
+
+			merger := &Merger{Strategies: test.strategies, ListKeys: test.listKeys}
+			overridden, err := merger.Merge(base, overlay)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := yamlv3.Marshal(base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, out)
+			}
+			if err := yamlv3.Unmarshal(out, &map[string]interface{}{}); err != nil {
+				t.Errorf("merged document does not parse: %v", err)
+			}
+			if !reflect.DeepEqual(overridden, test.overridden) {
+				t.Errorf("expected overridden %v, got %v", test.overridden, overridden)
+			}
+		})
+	}
+}
+
+func TestMergeNonMapping(t *testing.T) {
+	base := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal([]byte("- a\n- b\n"), base); err != nil {
+		t.Fatal(err)
+	}
+	overlay := &yamlv3.Node{}
+	if err := overlay.Encode(map[string]string{"discovery-url": "http://idp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	merger := &Merger{}
+	if _, err := merger.Merge(base, overlay); err == nil {
+		t.Error("expected an error merging into a sequence")
+	}
+}